@@ -0,0 +1,243 @@
+package openvpn
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+	"sync"
+	"time"
+)
+
+const DefaultAuthTimeout = time.Second * 30
+
+// AuthRequest is a client connection waiting for authentication, it is built
+// from a >CLIENT:CONNECT or >CLIENT:REAUTH block and its ENV lines. Instance
+// is the OpenVPN instance the client connected to.
+type AuthRequest struct {
+	Instance   string
+	ClientID   int
+	KeyID      int
+	Reauth     bool
+	Username   string
+	Password   string
+	CommonName string
+	IP         string
+	Env        map[string]string
+}
+
+// AuthResult is the answer of an Authenticator, Config is pushed to the
+// client when it is allowed (see client-auth). When Pending is set the
+// decision is deferred and made later through AuthDispatcher.Pending.
+type AuthResult struct {
+	Allow        bool
+	Reason       string
+	ClientReason string
+	Config       *ClientConfig
+	Pending      *PendingOptions
+}
+
+// Authenticator authenticates clients connecting to an OpenVPN server
+// running with --management-client-auth
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *AuthRequest) (*AuthResult, error)
+}
+
+// ChallengeResponder is implemented by authenticators that issue CR_TEXT
+// challenges (see ChallengeExtra), it receives the response of the client.
+type ChallengeResponder interface {
+	ChallengeResponse(ctx context.Context, pending *PendingAuth, response string) (*AuthResult, error)
+}
+
+// AuthDispatcher answers client authentication requests, every request is
+// authenticated in its own goroutine so a slow backend does not block others.
+type AuthDispatcher struct {
+	management    *OpenVpnManagement
+	authenticator Authenticator
+	Timeout       time.Duration
+	Pending       *PendingAuthRegistry
+	pending       sync.WaitGroup
+}
+
+func NewAuthDispatcher(vm *OpenVpnManagement, authenticator Authenticator) *AuthDispatcher {
+	return &AuthDispatcher{
+		management:    vm,
+		authenticator: authenticator,
+		Timeout:       DefaultAuthTimeout,
+		Pending:       NewPendingAuthRegistry(vm),
+	}
+}
+
+// NewAuthRequest builds a request from a CLIENT_CONNECT or CLIENT_REAUTH event
+func NewAuthRequest(evt core.EventData) (*AuthRequest, error) {
+	name := evt.EventName()
+	if name != "CLIENT_CONNECT" && name != "CLIENT_REAUTH" {
+		return nil, errors.New("not an authentication request: " + name)
+	}
+	req := &AuthRequest{
+		Instance:   evt.Instance,
+		ClientID:   evt.GetInt("client_id"),
+		KeyID:      evt.GetInt("key_id"),
+		Reauth:     name == "CLIENT_REAUTH",
+		Username:   evt.Get("username"),
+		Password:   evt.Get("password"),
+		CommonName: evt.Get("common_name"),
+		IP:         evt.Get("untrusted_ip"),
+		Env:        make(map[string]string),
+	}
+	if req.ClientID < 0 || req.KeyID < 0 {
+		return nil, errors.New("invalid client or key id")
+	}
+	for k, v := range evt.Data {
+		req.Env[k] = v
+	}
+	return req, nil
+}
+
+// Run subscribes to the client events and handles the authentication
+// requests until ctx is done or the management interface shuts down.
+func (ad *AuthDispatcher) Run(ctx context.Context) {
+	sub := ad.management.Subscribe(EventKinds("CLIENT"), SubscriptionOptions{Policy: OverflowBlock})
+	defer sub.Close()
+	for {
+		select {
+		case evt, ok := <-sub.Events():
+			if !ok {
+				ad.pending.Wait()
+				return
+			}
+			ad.Handle(ctx, evt)
+		case <-ctx.Done():
+			ad.pending.Wait()
+			return
+		}
+	}
+}
+
+// Handle starts the authentication of evt, it returns false if evt is not an
+// authentication request.
+func (ad *AuthDispatcher) Handle(ctx context.Context, evt core.EventData) bool {
+	if !evt.Completed {
+		return false
+	}
+	switch evt.EventName() {
+	case "CLIENT_DISCONNECT":
+		ad.Pending.Remove(evt.Instance, evt.GetInt("client_id"))
+		return false
+	case "CLIENT_CR_RESPONSE":
+		return ad.handleChallengeResponse(ctx, evt)
+	}
+	req, err := NewAuthRequest(evt)
+	if err != nil {
+		return false
+	}
+	ad.pending.Add(1)
+	go func() {
+		defer ad.pending.Done()
+		result := ad.run(ctx, req.ClientID, func(ctx context.Context) (*AuthResult, error) {
+			return ad.authenticator.Authenticate(ctx, req)
+		})
+		if err := ad.answer(req, result); err != nil {
+			glog.Errorf("Failed to answer authentication of client %d: %v", req.ClientID, err)
+		}
+	}()
+	return true
+}
+
+// handleChallengeResponse routes a CR_RESPONSE to the authenticator that
+// made the client pending
+func (ad *AuthDispatcher) handleChallengeResponse(ctx context.Context, evt core.EventData) bool {
+	cid, kid := evt.GetInt("client_id"), evt.GetInt("key_id")
+	pa, ok := ad.Pending.Get(evt.Instance, cid, kid)
+	if !ok {
+		glog.Warningf("Challenge response for client %d without pending authentication", cid)
+		return false
+	}
+	responder, ok := ad.authenticator.(ChallengeResponder)
+	if !ok {
+		glog.Warningf("Authenticator does not accept challenge responses, client %d", cid)
+		return false
+	}
+	response := evt.Get("cr_response")
+	ad.pending.Add(1)
+	go func() {
+		defer ad.pending.Done()
+		result := ad.run(ctx, cid, func(ctx context.Context) (*AuthResult, error) {
+			return responder.ChallengeResponse(ctx, pa, response)
+		})
+		if err := ad.answerPending(pa, result); err != nil {
+			glog.Errorf("Failed to answer challenge response of client %d: %v", cid, err)
+		}
+	}()
+	return true
+}
+
+// answerPending completes, denies or renews a pending authentication
+func (ad *AuthDispatcher) answerPending(pa *PendingAuth, result *AuthResult) error {
+	if result.Pending != nil {
+		_, err := ad.Pending.Start(pa.Request, *result.Pending)
+		return err
+	}
+	if !result.Allow {
+		reason := result.Reason
+		if reason == "" {
+			reason = "authentication failed"
+		}
+		return ad.Pending.Deny(pa.Instance, pa.ClientID, pa.KeyID, reason, result.ClientReason)
+	}
+	return ad.Pending.Complete(pa.Instance, pa.ClientID, pa.KeyID, result.Config)
+}
+
+// Wait blocks until all pending authentications are answered
+func (ad *AuthDispatcher) Wait() {
+	ad.pending.Wait()
+}
+
+// run calls auth under the dispatcher timeout, errors and timeouts deny the client
+func (ad *AuthDispatcher) run(ctx context.Context, cid int, auth func(ctx context.Context) (*AuthResult, error)) *AuthResult {
+	ctx, cancel := context.WithTimeout(ctx, ad.Timeout)
+	defer cancel()
+
+	results := make(chan *AuthResult, 1)
+	go func() {
+		result, err := auth(ctx)
+		if err != nil {
+			glog.Warningf("Authentication of client %d failed: %v", cid, err)
+			result = &AuthResult{Reason: err.Error()}
+		}
+		if result == nil {
+			result = &AuthResult{}
+		}
+		results <- result
+	}()
+
+	select {
+	case result := <-results:
+		return result
+	case <-ctx.Done():
+		return &AuthResult{Reason: "authentication timeout"}
+	}
+}
+
+func (ad *AuthDispatcher) answer(req *AuthRequest, result *AuthResult) error {
+	vm := ad.management.Instance(req.Instance)
+	if result.Pending != nil {
+		glog.V(1).Infof("Client %d (%s) pending: %s", req.ClientID, req.Username, result.Pending.Extra)
+		_, err := ad.Pending.Start(req, *result.Pending)
+		if err == nil {
+			return nil
+		}
+		glog.Warningf("Pending authentication of client %d failed: %v", req.ClientID, err)
+		result = &AuthResult{Reason: "pending authentication failed"}
+	}
+	if !result.Allow {
+		reason := result.Reason
+		if reason == "" {
+			reason = "authentication failed"
+		}
+		glog.V(1).Infof("Client %d (%s) denied: %s", req.ClientID, req.Username, reason)
+		return vm.ClientDeny(req.ClientID, req.KeyID, reason, result.ClientReason)
+	}
+	glog.V(1).Infof("Client %d (%s) authenticated", req.ClientID, req.Username)
+	return vm.clientAllow(req.ClientID, req.KeyID, result.Config)
+}
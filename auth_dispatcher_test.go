@@ -0,0 +1,145 @@
+package openvpn
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"github.com/mungaij83/go-openvpn/mgmttest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type slowAuthenticator struct {
+	release chan bool
+}
+
+func (sa slowAuthenticator) Authenticate(ctx context.Context, req *AuthRequest) (*AuthResult, error) {
+	if req.Username == "slow" {
+		select {
+		case <-sa.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if req.Password != "secret" {
+		return &AuthResult{Reason: "bad password", ClientReason: "Invalid username or password"}, nil
+	}
+	return &AuthResult{Allow: true, Config: configFor(req)}, nil
+}
+
+// configFor pushes an address to the clients with the common name pushed, and
+// an invalid config to the clients with the common name invalid
+func configFor(req *AuthRequest) *ClientConfig {
+	switch req.CommonName {
+	case "pushed":
+		return NewClientConfig().IfconfigPush("10.8.0.10", "255.255.255.0")
+	case "invalid":
+		return NewClientConfig().PushDNS("dns.example.com")
+	}
+	return nil
+}
+
+func clientConnect(t *testing.T, cid, kid int, username, password, cn string) core.EventData {
+	p := core.NewCommandParser()
+	lines := []string{
+		">CLIENT:CONNECT," + strconv.Itoa(cid) + "," + strconv.Itoa(kid),
+		">CLIENT:ENV,untrusted_ip=10.13.156.4",
+		">CLIENT:ENV,common_name=" + cn,
+		">CLIENT:ENV,username=" + username,
+		">CLIENT:ENV,password=" + password,
+		">CLIENT:ENV,END",
+	}
+	for _, l := range lines {
+		if evt := p.ParseEvent(l); evt != nil {
+			return *evt
+		}
+	}
+	t.Fatal("Incomplete client connect")
+	return core.EventData{}
+}
+
+func TestAuthDispatcher(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-auth command succeeded")
+	auth := slowAuthenticator{release: make(chan bool)}
+	ad := NewAuthDispatcher(vm, auth)
+	ctx := context.Background()
+
+	if !ad.Handle(ctx, clientConnect(t, 1, 0, "slow", "secret", "slow")) {
+		t.Fatal("Connect not handled")
+	}
+	ad.Handle(ctx, clientConnect(t, 2, 1, "user", "wrong", "user"))
+	ad.Handle(ctx, clientConnect(t, 3, 0, "user", "secret", "pushed"))
+	commands := f.waitCommands(t, 2)
+	close(auth.release)
+	ad.Wait()
+	commands = f.waitCommands(t, 3)
+
+	expected := map[string]bool{
+		`client-deny 2 1 "bad password" "Invalid username or password"`: true,
+		"client-auth 3 0\nifconfig-push 10.8.0.10 255.255.255.0\nEND":   true,
+	}
+	for _, c := range commands[:2] {
+		if !expected[c] {
+			t.Errorf("Unexpected command: %q", c)
+		}
+	}
+	if commands[2] != "client-auth-nt 1 0" {
+		t.Errorf("Slow client answered out of order: %v", commands)
+	}
+}
+
+func TestAuthDispatcherTimeout(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-deny command succeeded")
+	ad := NewAuthDispatcher(vm, slowAuthenticator{release: make(chan bool)})
+	ad.Timeout = time.Millisecond * 10
+	ad.Handle(context.Background(), clientConnect(t, 4, 2, "slow", "secret", "slow"))
+	ad.Wait()
+	commands := f.waitCommands(t, 1)
+	if commands[0] != `client-deny 4 2 "authentication timeout"` {
+		t.Errorf("Unexpected command: %q", commands[0])
+	}
+}
+
+func TestAuthDispatcherInvalidConfig(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-deny command succeeded")
+	ad := NewAuthDispatcher(vm, slowAuthenticator{})
+	ad.Handle(context.Background(), clientConnect(t, 5, 0, "user", "secret", "invalid"))
+	ad.Wait()
+	commands := f.waitCommands(t, 1)
+	if commands[0] != `client-deny 5 0 "invalid client config"` {
+		t.Errorf("Unexpected command: %q", commands[0])
+	}
+}
+
+func TestAuthDispatcherTranscript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "management.sock")
+	vm := NewVpnManagement("", socket, 0, "secret", core.ServerMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err = vm.StartServer(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Shutdown(ctx)
+	ad := NewAuthDispatcher(vm, slowAuthenticator{})
+	go ad.Run(ctx)
+
+	srv := mgmttest.New(t)
+	defer srv.Close()
+	srv.Password = "secret"
+	srv.Pid = 2871
+	srv.Dial("unix", socket)
+	srv.ExpectCommand("pid")
+	srv.ExpectCommand("version")
+	if err = srv.ReplayFile("testdata/client_connect.txt"); err != nil {
+		t.Fatal(err)
+	}
+	srv.ExpectCommand("client-auth-nt 0 1")
+}
@@ -0,0 +1,177 @@
+package openvpn
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+	"net"
+	"strings"
+)
+
+// ClientConfig builds the per-client config directives sent with client-auth,
+// the same directives that would be stored in a client-config-dir file.
+// Errors are kept and returned by Lines.
+type ClientConfig struct {
+	lines []string
+	err   error
+}
+
+func NewClientConfig() *ClientConfig {
+	return &ClientConfig{lines: make([]string, 0)}
+}
+
+func (cc *ClientConfig) add(line string) *ClientConfig {
+	if cc.err == nil {
+		cc.lines = append(cc.lines, line)
+	}
+	return cc
+}
+
+func (cc *ClientConfig) fail(err error) *ClientConfig {
+	if cc.err == nil {
+		cc.err = err
+	}
+	return cc
+}
+
+// Push pushes option to the client, e.g. "route-gateway 10.8.0.1"
+func (cc *ClientConfig) Push(option string) *ClientConfig {
+	option = strings.TrimSpace(option)
+	if option == "" || strings.ContainsAny(option, "\"\r\n") {
+		return cc.fail(fmt.Errorf("invalid push option: %q", option))
+	}
+	return cc.add(fmt.Sprintf("push \"%s\"", option))
+}
+
+// PushRoute pushes a route to network (CIDR), the gateway is optional
+func (cc *ClientConfig) PushRoute(network string, gateway string) *ClientConfig {
+	ip, mask, err := parseNetwork(network)
+	if err != nil {
+		return cc.fail(err)
+	}
+	if ip.To4() == nil {
+		return cc.Push(strings.TrimSpace(fmt.Sprintf("route-ipv6 %s %s", network, gateway)))
+	}
+	if gateway != "" && net.ParseIP(gateway) == nil {
+		return cc.fail(fmt.Errorf("invalid gateway: %q", gateway))
+	}
+	return cc.Push(strings.TrimSpace(fmt.Sprintf("route %s %s %s", ip, mask, gateway)))
+}
+
+// PushDNS pushes a DNS server
+func (cc *ClientConfig) PushDNS(server string) *ClientConfig {
+	if net.ParseIP(server) == nil {
+		return cc.fail(fmt.Errorf("invalid DNS server: %q", server))
+	}
+	return cc.Push(fmt.Sprintf("dhcp-option DNS %s", server))
+}
+
+// PushDomain pushes the DNS domain of the VPN
+func (cc *ClientConfig) PushDomain(domain string) *ClientConfig {
+	if domain == "" || strings.ContainsAny(domain, " \t") {
+		return cc.fail(fmt.Errorf("invalid domain: %q", domain))
+	}
+	return cc.Push(fmt.Sprintf("dhcp-option DOMAIN %s", domain))
+}
+
+// RedirectGateway routes all client traffic through the VPN, e.g. with flags def1 bypass-dhcp
+func (cc *ClientConfig) RedirectGateway(flags ...string) *ClientConfig {
+	return cc.Push(strings.TrimSpace("redirect-gateway " + strings.Join(flags, " ")))
+}
+
+// IfconfigPush assigns a static IPv4 address, the second argument is the
+// netmask (topology subnet) or the remote endpoint (topology net30)
+func (cc *ClientConfig) IfconfigPush(local, netmask string) *ClientConfig {
+	if net.ParseIP(local).To4() == nil || net.ParseIP(netmask).To4() == nil {
+		return cc.fail(fmt.Errorf("invalid ifconfig-push: %s %s", local, netmask))
+	}
+	return cc.add(fmt.Sprintf("ifconfig-push %s %s", local, netmask))
+}
+
+// IfconfigIPv6Push assigns a static IPv6 address (CIDR) with the server as remote
+func (cc *ClientConfig) IfconfigIPv6Push(address, remote string) *ClientConfig {
+	ip, _, err := net.ParseCIDR(address)
+	if err != nil || ip.To4() != nil || net.ParseIP(remote) == nil {
+		return cc.fail(fmt.Errorf("invalid ifconfig-ipv6-push: %s %s", address, remote))
+	}
+	return cc.add(fmt.Sprintf("ifconfig-ipv6-push %s %s", address, remote))
+}
+
+// IRoute routes network (CIDR) to the client, the server must also have a route to it
+func (cc *ClientConfig) IRoute(network string) *ClientConfig {
+	ip, mask, err := parseNetwork(network)
+	if err != nil {
+		return cc.fail(err)
+	}
+	if ip.To4() == nil {
+		return cc.add(fmt.Sprintf("iroute-ipv6 %s", network))
+	}
+	return cc.add(fmt.Sprintf("iroute %s %s", ip, mask))
+}
+
+// Option adds a raw directive, e.g. "disable" or "comp-lzo no"
+func (cc *ClientConfig) Option(directive string) *ClientConfig {
+	directive = strings.TrimSpace(directive)
+	if directive == "" || strings.EqualFold(directive, core.ResponseEnd) {
+		return cc.fail(fmt.Errorf("invalid directive: %q", directive))
+	}
+	if err := core.ValidateArgs(directive); err != nil {
+		return cc.fail(err)
+	}
+	return cc.add(directive)
+}
+
+// Lines returns the directives, or the first error of the builder
+func (cc *ClientConfig) Lines() ([]string, error) {
+	if cc == nil {
+		return nil, nil
+	}
+	if cc.err != nil {
+		return nil, cc.err
+	}
+	return cc.lines, nil
+}
+
+func (cc *ClientConfig) String() string {
+	return strings.Join(cc.lines, "\n")
+}
+
+// parseNetwork returns the network address and, for IPv4, the dotted netmask
+func parseNetwork(network string) (net.IP, string, error) {
+	_, n, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid network: %q", network)
+	}
+	if n.IP.To4() == nil {
+		return n.IP, "", nil
+	}
+	return n.IP, net.IP(n.Mask).String(), nil
+}
+
+// ClientAuthConfig authorizes a client connection and sends config in the
+// same client-auth block.
+func (c *Commands) ClientAuthConfig(cid, kid int, config *ClientConfig) error {
+	lines, err := config.Lines()
+	if err != nil {
+		return err
+	}
+	return c.ClientAuth(cid, kid, lines...)
+}
+
+// clientAllow authorizes a client with config, which may be nil. OpenVPN
+// waits for an answer until hand-window, so the client is denied when the
+// config is invalid.
+func (c *Commands) clientAllow(cid, kid int, config *ClientConfig) error {
+	if config == nil {
+		return c.ClientAuthNT(cid, kid)
+	}
+	lines, err := config.Lines()
+	if err != nil {
+		glog.Errorf("Invalid config of client %d: %v", cid, err)
+		if denyErr := c.ClientDeny(cid, kid, "invalid client config", ""); denyErr != nil {
+			return denyErr
+		}
+		return fmt.Errorf("client %d denied: %v", cid, err)
+	}
+	return c.ClientAuth(cid, kid, lines...)
+}
@@ -0,0 +1,58 @@
+package openvpn
+
+import (
+	"testing"
+)
+
+func TestClientConfig(t *testing.T) {
+	cc := NewClientConfig().
+		IfconfigPush("10.8.0.10", "255.255.255.0").
+		PushRoute("192.168.10.0/24", "").
+		PushRoute("fd00:10::/64", "").
+		PushDNS("10.8.0.1").
+		PushDomain("vpn.example.com").
+		IRoute("10.20.0.0/16")
+	lines, err := cc.Lines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"ifconfig-push 10.8.0.10 255.255.255.0",
+		`push "route 192.168.10.0 255.255.255.0"`,
+		`push "route-ipv6 fd00:10::/64"`,
+		`push "dhcp-option DNS 10.8.0.1"`,
+		`push "dhcp-option DOMAIN vpn.example.com"`,
+		"iroute 10.20.0.0 255.255.0.0",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected config: %v", lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestClientConfigInvalid(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-auth command succeeded")
+	cc := NewClientConfig().PushDNS("10.8.0.1").Push(`route 10.0.0.0 255.0.0.0" "x`).Option("END")
+	if err := vm.ClientAuthConfig(1, 0, cc); err == nil {
+		t.Error("Expected invalid push error")
+	}
+	if len(f.commands) != 0 {
+		t.Errorf("Invalid config was sent: %v", f.commands)
+	}
+}
+
+func TestClientAuthWithClientConfig(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-auth command succeeded")
+	cc := NewClientConfig().IfconfigPush("10.8.0.10", "255.255.255.0").RedirectGateway("def1")
+	if err := vm.ClientAuthConfig(7, 1, cc); err != nil {
+		t.Fatal(err)
+	}
+	expected := "client-auth 7 1\nifconfig-push 10.8.0.10 255.255.255.0\npush \"redirect-gateway def1\"\nEND"
+	if f.commands[0] != expected {
+		t.Errorf("Unexpected command: %q", f.commands[0])
+	}
+}
@@ -12,13 +12,14 @@ import (
 )
 
 type Config struct {
-	remote     string
-	port       int
-	ipAddress  string
-	socketPath string
-	flags      map[string]bool
-	values     map[string]string
-	params     []string
+	remote       string
+	port         int
+	ipAddress    string
+	socketPath   string
+	passwordFile string
+	flags        map[string]bool
+	values       map[string]string
+	params       []string
 }
 
 func NewConfig(socket string) *Config {
@@ -35,15 +36,26 @@ func NewConfig(socket string) *Config {
 	c.Flag("management-up-down")
 	c.Flag("management-client")
 	if socket != "" {
-		c.Set("management", fmt.Sprintf("%s unix", socket))
+		c.Set("management", c.managementAddress())
 		//c.Flag("management-hold")
 		glog.Infof("Current config: %v", c)
 	} else if c.ipAddress != "" {
-		c.Set("management", fmt.Sprintf("%s %d", c.ipAddress, c.port))
+		c.Set("management", c.managementAddress())
 	}
 	return c
 }
 
+func (c *Config) managementAddress() string {
+	address := fmt.Sprintf("%s %d", c.ipAddress, c.port)
+	if c.socketPath != "" {
+		address = fmt.Sprintf("%s unix", c.socketPath)
+	}
+	if c.passwordFile != "" {
+		address = fmt.Sprintf("%s %s", address, c.passwordFile)
+	}
+	return address
+}
+
 func (c *Config) Set(key, val string) {
 	a := strings.Split("--"+key+" "+val, " ")
 	for _, ar := range a {
@@ -86,22 +98,56 @@ func (c *Config) ServerMode(port int, ca *openssl.CA, cert *openssl.Cert, dh *op
 	}
 }
 
+// ClientMode configures a TLS client, when cert is nil the certificate and
+// key are supplied through the management interface (see ExternalCertificate)
 func (c *Config) ClientMode(ca *openssl.CA, cert *openssl.Cert, dh *openssl.DH, ta *openssl.TA) {
 	c.Flag("client")
 	c.Flag("tls-client")
 
 	c.Set("ca", ca.GetFilePath())
-	c.Set("cert", cert.GetFilePath())
-	c.Set("key", cert.GetKeyPath())
+	if cert != nil {
+		c.Set("cert", cert.GetFilePath())
+		c.Set("key", cert.GetKeyPath())
+	} else {
+		c.ExternalKey()
+		c.ExternalCertificate("external")
+	}
 	c.Set("dh", dh.GetFilePath())
 	c.Set("tls-auth", ta.GetFilePath())
 }
 
+// ExternalKey makes OpenVPN request signatures with PK_SIGN instead of
+// reading a key file, flags are e.g. pkcs1, pss or nopadding.
+func (c *Config) ExternalKey(flags ...string) {
+	if len(flags) == 0 {
+		c.Flag("management-external-key")
+	} else {
+		c.Set("management-external-key", strings.Join(flags, " "))
+	}
+}
+
+// ExternalCertificate makes OpenVPN request the certificate with NEED-CERTIFICATE,
+// hint is passed to the CertificateProvider.
+func (c *Config) ExternalCertificate(hint string) {
+	c.Set("management-external-cert", hint)
+}
+
 func (c *Config) Remote(r string, port int) {
 	c.Set("port", strconv.Itoa(port))
 	c.Set("remote", r)
 	c.remote = r
 }
+
+// QueryRemote makes OpenVPN ask the RemoteSelector before connecting to a remote
+func (c *Config) QueryRemote() {
+	c.Flag("management-query-remote")
+}
+
+// QueryProxy makes OpenVPN ask the ProxySelector which proxy to use
+func (c *Config) QueryProxy() {
+	c.Flag("management-query-proxy")
+}
+
 func (c *Config) Protocol(p string) {
 	c.Set("proto", p)
 }
@@ -126,7 +172,39 @@ func (c *Config) Secret(key string) {
 func (c *Config) Address(address string, port int) {
 	c.ipAddress = address
 	c.port = port
-	c.Set("management", fmt.Sprintf("%s %d", c.ipAddress, c.port))
+	c.socketPath = ""
+	c.setManagement()
+}
+
+// PasswordFile protects the management interface with the password stored
+// on the first line of file, the connector must be created with the same password
+func (c *Config) PasswordFile(file string) {
+	c.passwordFile = file
+	c.setManagement()
+}
+
+// setManagement replaces the values of the --management parameter, it is
+// added if it is not set
+func (c *Config) setManagement() {
+	values := strings.Split(c.managementAddress(), " ")
+	params := make([]string, 0, len(c.params)+len(values))
+	found, skip := false, false
+	for _, p := range c.params {
+		if strings.HasPrefix(p, "--") {
+			skip = p == "--management"
+		}
+		if !skip {
+			params = append(params, p)
+		} else if !found {
+			found = true
+			params = append(params, "--management")
+			params = append(params, values...)
+		}
+	}
+	c.params = params
+	if !found {
+		c.Set("management", c.managementAddress())
+	}
 }
 
 func (c *Config) Port() int {
@@ -0,0 +1,35 @@
+package openvpn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordFile(t *testing.T) {
+	c := NewConfig("")
+	c.Address("127.0.0.1", 17505)
+	c.PasswordFile("/etc/openvpn/management.pw")
+	params, _ := c.Validate()
+	expected := []string{
+		"--management-signal",
+		"--management-up-down",
+		"--management-client",
+		"--management", "127.0.0.1", "17505", "/etc/openvpn/management.pw",
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Invalid management parameters: %v", params)
+	}
+}
+
+func TestExternalCertificate(t *testing.T) {
+	c := NewConfig("/var/run/openvpn.sock")
+	c.ExternalKey("pkcs1", "pss")
+	c.ExternalCertificate("macosx-keychain:subject:o=OpenVPN-TEST")
+	params, _ := c.Validate()
+	args := strings.Join(params, " ")
+	if !strings.Contains(args, "--management-external-key pkcs1 pss") ||
+		!strings.Contains(args, "--management-external-cert macosx-keychain:subject:o=OpenVPN-TEST") {
+		t.Errorf("Invalid external certificate parameters: %v", args)
+	}
+}
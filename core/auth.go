@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	passwordPrompt   = "ENTER PASSWORD:"
+	handshakeTimeout = time.Second * 10
+)
+
+// ErrBadPassword is returned when OpenVPN rejects the management password
+var ErrBadPassword = errors.New("management interface password rejected")
+
+// authenticate answers the password prompt sent by OpenVPN when the
+// management interface is started with a pw-file. The prompt is not
+// terminated by a newline, so it is read before any line based reader.
+func authenticate(ctx context.Context, c net.Conn, reader *bufio.Reader, password string) error {
+	if password == "" {
+		return nil
+	}
+	deadline := time.Now().Add(handshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = c.SetDeadline(deadline)
+	defer c.SetDeadline(time.Time{})
+	// Interrupt the handshake when ctx is cancelled
+	done := make(chan bool)
+	interrupted := make(chan bool)
+	go func() {
+		defer close(interrupted)
+		select {
+		case <-ctx.Done():
+			_ = c.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-interrupted
+	}()
+
+	prompt, err := reader.Peek(len(passwordPrompt))
+	if err != nil {
+		return fmt.Errorf("failed to read password prompt: %v", err)
+	}
+	if string(prompt) != passwordPrompt {
+		glog.Warningf("Management interface did not ask for a password")
+		return nil
+	}
+	_, _ = reader.Discard(len(passwordPrompt))
+
+	_, err = c.Write([]byte(fmt.Sprintf("%s\n", password)))
+	if err != nil {
+		return err
+	}
+	tp := textproto.NewReader(reader)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return fmt.Errorf("failed to read password response: %v", err)
+		}
+		line = strings.TrimPrefix(line, passwordPrompt)
+		if strings.HasPrefix(line, ResponseSuccess+":") {
+			glog.V(2).Infof("Management password accepted")
+			return nil
+		}
+		if strings.HasPrefix(line, ResponseError+":") {
+			return ErrBadPassword
+		}
+	}
+}
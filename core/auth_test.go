@@ -0,0 +1,65 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// passwordServer accepts one connection on a unix socket and asks for password
+func passwordServer(t *testing.T, password string) string {
+	dir, err := ioutil.TempDir("", "openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "management.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer os.RemoveAll(dir)
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = c.Write([]byte(passwordPrompt))
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		if line != password+"\n" {
+			_, _ = c.Write([]byte("ERROR: bad password\n"))
+			return
+		}
+		_, _ = c.Write([]byte("SUCCESS: password is correct\n" +
+			">INFO:OpenVPN Management Interface Version 1 -- type 'help' for more info\n"))
+		_, _ = bufio.NewReader(c).ReadString('\n')
+	}()
+	return socket
+}
+
+func TestConnectPassword(t *testing.T) {
+	socket := passwordServer(t, "secret")
+	c := NewSocketConnector(socket, "secret", ClientMode)
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string, 1)
+	c.Listen(context.Background(), events)
+	if e := <-events; e != ">INFO:OpenVPN Management Interface Version 1 -- type 'help' for more info" {
+		t.Errorf("unexpected event: %v", e)
+	}
+	_ = c.Close()
+}
+
+func TestConnectBadPassword(t *testing.T) {
+	socket := passwordServer(t, "secret")
+	c := NewSocketConnector(socket, "wrong", ClientMode)
+	if err := c.Connect(context.Background()); err != ErrBadPassword {
+		t.Errorf("expected bad password error, got %v", err)
+	}
+}
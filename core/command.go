@@ -0,0 +1,43 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quote returns arg as a double quoted management command argument, escaping
+// backslashes and double quotes.
+func Quote(arg string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// ValidateArgs rejects arguments that would break the line based protocol
+func ValidateArgs(args ...string) error {
+	for _, a := range args {
+		if strings.ContainsAny(a, "\r\n\x00") {
+			return fmt.Errorf("invalid argument: %q", a)
+		}
+	}
+	return nil
+}
+
+// ParseResponse parses the text returned by OpenVpnConnector.SendCommand
+func ParseResponse(text string) *Response {
+	text = strings.TrimRight(text, "\r\n")
+	if status, msg, ok := parseStatusLine(text); ok && !strings.Contains(text, "\n") {
+		return &Response{Status: status, Message: msg}
+	}
+	resp := &Response{Lines: make([]string, 0)}
+	if text == "" {
+		return resp
+	}
+	for _, l := range strings.Split(text, "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		if l == ResponseEnd {
+			break
+		}
+		resp.Lines = append(resp.Lines, l)
+	}
+	return resp
+}
@@ -1,13 +1,418 @@
 package core
 
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
 const (
 	ServerMode = 1 // OpenVPN running in server mode
 	ClientMode = 2 // OpenVPN running in client mode
 )
 
 type OpenVpnConnector interface {
-	Connect() error
-	SendCommand(string) (string, error)
-	Listen(events chan string)
+	// Connect dials OpenVPN in client mode, or listens for OpenVPN to
+	// connect (--management-client) in server mode
+	Connect(ctx context.Context) error
+	// SendCommand returns the response of command, it is safe for
+	// concurrent use. In server mode it waits for OpenVPN to connect.
+	SendCommand(ctx context.Context, command string) (string, error)
+	// Listen sends the lines received from OpenVPN to events until ctx is
+	// done or the connector is closed
+	Listen(ctx context.Context, events chan string)
+	// Close stops the connector and waits for its goroutines, it may be
+	// called more than once
 	Close() error
 }
+
+// connector implements the lifecycle shared by TcpConnector and
+// SocketConnector. Every goroutine it starts is tracked and joined by Close.
+// In both modes commands are sent on a session. In server mode every
+// connection accepted from OpenVPN is an instance with its own session,
+// SendCommand uses the session of the last instance that connected.
+type connector struct {
+	network  string
+	address  string
+	password string
+	mode     int
+	shutdown chan bool
+	stopOnce sync.Once
+	lock     sync.Mutex
+	session  *session
+	// ready is closed while session is set
+	ready     chan bool
+	listener  net.Listener
+	conns     map[net.Conn]bool
+	instances map[string]*instance
+	accepted  int
+	tap       Tap
+	routines  sync.WaitGroup
+}
+
+// instance is the session of an OpenVPN instance connected in server mode
+type instance struct {
+	Instance
+	session *session
+}
+
+func newConnector(network, address, password string, mode int) *connector {
+	return &connector{
+		network:   network,
+		address:   address,
+		password:  password,
+		mode:      mode,
+		shutdown:  make(chan bool),
+		ready:     make(chan bool),
+		conns:     make(map[net.Conn]bool),
+		instances: make(map[string]*instance),
+	}
+}
+
+func (c *connector) Connect(ctx context.Context) error {
+	select {
+	case <-c.shutdown:
+		return ErrConnectionClosed
+	default:
+	}
+	if c.mode == ServerMode {
+		var lc net.ListenConfig
+		l, err := lc.Listen(ctx, c.network, c.address)
+		if err != nil {
+			return err
+		}
+		glog.V(2).Infof("Management listening on: %v", l.Addr())
+		c.lock.Lock()
+		c.listener = l
+		c.lock.Unlock()
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return err
+	}
+	s := c.newSession(conn)
+	if err = s.authenticate(ctx, c.password); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	c.setSession(s)
+	if !c.spawn(s.readLoop) {
+		_ = s.Close()
+		return ErrConnectionClosed
+	}
+	glog.V(2).Infof("Management connected: %v", conn.RemoteAddr())
+	return nil
+}
+
+func (c *connector) SendCommand(ctx context.Context, command string) (string, error) {
+	s, err := c.activeSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.send(ctx, s, command)
+}
+
+func (c *connector) SendCommandTo(ctx context.Context, instance string, command string) (string, error) {
+	if instance == "" {
+		return c.SendCommand(ctx, command)
+	}
+	c.lock.Lock()
+	i, ok := c.instances[instance]
+	c.lock.Unlock()
+	if !ok {
+		return "", ErrUnknownInstance
+	}
+	return c.send(ctx, i.session, command)
+}
+
+func (c *connector) send(ctx context.Context, s *session, command string) (string, error) {
+	resp, err := s.Send(ctx, command)
+	if err != nil {
+		glog.V(2).Infof("Failed to send command: %v", err)
+		return "", err
+	}
+	glog.V(2).Infof("Command response: %v", resp)
+	return resp.String(), resp.Err()
+}
+
+func (c *connector) Listen(ctx context.Context, events chan string) {
+	lines := make(chan InstanceLine)
+	if !c.spawn(func() { c.untag(lines, events) }) {
+		return
+	}
+	c.ListenInstances(ctx, lines)
+}
+
+func (c *connector) ListenInstances(ctx context.Context, lines chan InstanceLine) {
+	c.lock.Lock()
+	s, l := c.session, c.listener
+	c.lock.Unlock()
+	if !c.spawn(func() {
+		select {
+		case <-ctx.Done():
+			_ = c.stop()
+		case <-c.shutdown:
+		}
+	}) {
+		return
+	}
+	if c.mode == ServerMode && l != nil {
+		c.spawn(func() { c.accept(l, lines) })
+	} else if s != nil {
+		c.spawn(func() { s.forward("", lines) })
+	}
+}
+
+// untag forwards the lines of every instance to events
+func (c *connector) untag(lines chan InstanceLine, events chan string) {
+	for {
+		select {
+		case l := <-lines:
+			select {
+			case events <- l.Line:
+			case <-c.shutdown:
+				return
+			}
+		case <-c.shutdown:
+			return
+		}
+	}
+}
+
+// Instances returns the instances connected in server mode
+func (c *connector) Instances() []Instance {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	instances := make([]Instance, 0, len(c.instances))
+	for _, i := range c.instances {
+		instances = append(instances, i.Instance)
+	}
+	sort.Slice(instances, func(a, b int) bool {
+		return instances[a].ConnectedAt.Before(instances[b].ConnectedAt)
+	})
+	return instances
+}
+
+// SetTap records the sessions started after it is called
+func (c *connector) SetTap(tap Tap) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tap = tap
+}
+
+func (c *connector) newSession(conn net.Conn) *session {
+	s := newSession(conn)
+	c.lock.Lock()
+	s.tap = c.tap
+	c.lock.Unlock()
+	return s
+}
+
+// setSession makes s the session commands are sent to
+func (c *connector) setSession(s *session) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.session == nil {
+		close(c.ready)
+	}
+	c.session = s
+}
+
+// register adds the instance served by s, its ID is the pid of OpenVPN or a
+// sequence number when the pid is unknown or already used.
+func (c *connector) register(s *session, pid int, version *VersionInfo) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.accepted++
+	id := "instance-" + strconv.Itoa(c.accepted)
+	if pid > 0 {
+		id = strconv.Itoa(pid)
+		if _, ok := c.instances[id]; ok {
+			id = fmt.Sprintf("%d-%d", pid, c.accepted)
+		}
+	}
+	c.instances[id] = &instance{
+		Instance: Instance{
+			ID:          id,
+			Pid:         pid,
+			Version:     version,
+			RemoteAddr:  s.conn.RemoteAddr().String(),
+			ConnectedAt: time.Now(),
+		},
+		session: s,
+	}
+	if c.session == nil {
+		close(c.ready)
+	}
+	c.session = s
+	return id
+}
+
+// unregister removes an instance, SendCommand falls back to the instance
+// that connected last.
+func (c *connector) unregister(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i, ok := c.instances[id]
+	if !ok {
+		return
+	}
+	delete(c.instances, id)
+	if c.session != i.session {
+		return
+	}
+	var last *instance
+	for _, other := range c.instances {
+		if last == nil || other.ConnectedAt.After(last.ConnectedAt) {
+			last = other
+		}
+	}
+	if last != nil {
+		c.session = last.session
+		return
+	}
+	c.session = nil
+	c.ready = make(chan bool)
+}
+
+// activeSession returns the session commands are sent to, in server mode it
+// waits until OpenVPN connects
+func (c *connector) activeSession(ctx context.Context) (*session, error) {
+	for {
+		c.lock.Lock()
+		s, ready := c.session, c.ready
+		c.lock.Unlock()
+		if s != nil {
+			return s, nil
+		}
+		if c.mode != ServerMode {
+			return nil, ErrConnectionClosed
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.shutdown:
+			return nil, ErrConnectionClosed
+		}
+	}
+}
+
+// spawn runs f in a goroutine joined by Close, it returns false once the
+// connector is stopped
+func (c *connector) spawn(f func()) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.shutdown:
+		return false
+	default:
+	}
+	c.routines.Add(1)
+	go func() {
+		defer c.routines.Done()
+		f()
+	}()
+	return true
+}
+
+func (c *connector) accept(l net.Listener, lines chan InstanceLine) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-c.shutdown:
+				glog.Info("Management: closed")
+			default:
+				glog.Errorf("Accept failed: %v", err)
+			}
+			return
+		}
+		glog.Info("Management: openvpn management interface have connected")
+		c.lock.Lock()
+		c.conns[conn] = true
+		c.lock.Unlock()
+		if !c.spawn(func() { c.serve(conn, lines) }) {
+			c.untrack(conn)
+			return
+		}
+	}
+}
+
+func (c *connector) untrack(conn net.Conn) {
+	c.lock.Lock()
+	delete(c.conns, conn)
+	c.lock.Unlock()
+	_ = conn.Close()
+}
+
+// serve reads a connection accepted from OpenVPN until it is closed, the
+// instance is identified before its notifications are forwarded.
+func (c *connector) serve(conn net.Conn, lines chan InstanceLine) {
+	defer c.untrack(conn)
+	glog.V(2).Infof("Serving client: %v", conn.RemoteAddr())
+	s := c.newSession(conn)
+	if err := s.authenticate(context.Background(), c.password); err != nil {
+		glog.Errorf("Management authentication failed: %v", err)
+		return
+	}
+	if !c.spawn(s.readLoop) {
+		return
+	}
+	pid, version := identify(s)
+	id := c.register(s, pid, version)
+	defer c.unregister(id)
+	s.setInstance(id)
+	glog.V(2).Infof("Management instance %s connected: %v", id, conn.RemoteAddr())
+	if !c.emit(lines, InstanceLine{id, ConnectionUp}) {
+		return
+	}
+	s.forward(id, lines)
+	// Commands to the instance fail before its ConnectionDown is received
+	c.unregister(id)
+	glog.V(2).Infof("Management instance %s disconnected", id)
+	c.emit(lines, InstanceLine{id, ConnectionDown})
+}
+
+// emit sends a line unless the connector is stopped
+func (c *connector) emit(lines chan InstanceLine, l InstanceLine) bool {
+	select {
+	case lines <- l:
+		return true
+	case <-c.shutdown:
+		return false
+	}
+}
+
+// stop closes the connections, it is safe to call more than once
+func (c *connector) stop() error {
+	var err error
+	c.stopOnce.Do(func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		close(c.shutdown)
+		if c.session != nil {
+			err = c.session.Close()
+		}
+		if c.listener != nil {
+			err = c.listener.Close()
+		}
+		for conn := range c.conns {
+			_ = conn.Close()
+		}
+	})
+	return err
+}
+
+func (c *connector) Close() error {
+	err := c.stop()
+	c.routines.Wait()
+	return err
+}
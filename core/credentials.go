@@ -0,0 +1,156 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	passwordNeedReg   = regexp.MustCompile(`^Need '([^']*)' (username/password|password)(?: SC:(\d+),(.*))?$`)
+	passwordFailedReg = regexp.MustCompile(`^Verification Failed: '([^']*)'(?: \['(CRV1:[^']*)'\])?`)
+	needReg           = regexp.MustCompile(`^Need '([^']*)' (?:confirmation|input)(?: MSG:(.*))?$`)
+)
+
+// authTokenPrefix starts the token pushed by the server with auth-token, OpenVPN
+// uses it in place of the password when it authenticates again.
+const authTokenPrefix = "Auth-Token:"
+
+// StaticChallenge is requested with SC: when OpenVPN runs with --static-challenge,
+// Concat is set by OpenVPN 2.6 for the concat format of the challenge
+type StaticChallenge struct {
+	Echo   bool
+	Concat bool
+	Text   string
+}
+
+// Password combines password and the response in the format requested
+func (sc StaticChallenge) Password(password, response string) string {
+	if sc.Concat {
+		return password + response
+	}
+	return StaticChallengePassword(password, response)
+}
+
+// DynamicChallenge is returned by the server in a failed verification as
+// CRV1:flags:state_id:base64_username:challenge_text
+type DynamicChallenge struct {
+	Flags    string
+	StateID  string
+	Username string
+	Text     string
+}
+
+// Echo reports if the response may be echoed while typing
+func (dc DynamicChallenge) Echo() bool {
+	return strings.Contains(dc.Flags, "E")
+}
+
+// ResponseRequired reports if the challenge requires a response
+func (dc DynamicChallenge) ResponseRequired() bool {
+	return strings.Contains(dc.Flags, "R")
+}
+
+// Password returns the password answering the challenge
+func (dc DynamicChallenge) Password(response string) string {
+	return fmt.Sprintf("CRV1::%s::%s", dc.StateID, response)
+}
+
+// PasswordEvent is a parsed >PASSWORD: notification, AuthToken is set by
+// >PASSWORD:Auth-Token: which does not expect an answer
+type PasswordEvent struct {
+	Type               string
+	NeedUsername       bool
+	VerificationFailed bool
+	StaticChallenge    *StaticChallenge
+	DynamicChallenge   *DynamicChallenge
+	AuthToken          string
+	Message            string
+}
+
+// NeedEvent is a parsed >NEED-OK: or >NEED-STR: notification
+type NeedEvent struct {
+	Name    string
+	Message string
+}
+
+// StaticChallengePassword combines password and the static challenge response
+func StaticChallengePassword(password, response string) string {
+	return fmt.Sprintf("SCRV1:%s:%s",
+		base64.StdEncoding.EncodeToString([]byte(password)),
+		base64.StdEncoding.EncodeToString([]byte(response)))
+}
+
+func ParsePassword(data string) (*PasswordEvent, error) {
+	if strings.HasPrefix(data, authTokenPrefix) {
+		return &PasswordEvent{
+			Type:      "Auth-Token",
+			AuthToken: strings.TrimPrefix(data, authTokenPrefix),
+			Message:   data,
+		}, nil
+	}
+	if m := passwordNeedReg.FindStringSubmatch(data); m != nil {
+		pe := &PasswordEvent{
+			Type:         m[1],
+			NeedUsername: m[2] == "username/password",
+			Message:      data,
+		}
+		if m[3] != "" {
+			// Bit 0 requests echo, bit 1 the concat format
+			flags, err := strconv.Atoi(m[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid static challenge flags: %s", m[3])
+			}
+			pe.StaticChallenge = &StaticChallenge{
+				Echo:   flags&1 != 0,
+				Concat: flags&2 != 0,
+				Text:   m[4],
+			}
+		}
+		return pe, nil
+	}
+	if m := passwordFailedReg.FindStringSubmatch(data); m != nil {
+		pe := &PasswordEvent{
+			Type:               m[1],
+			VerificationFailed: true,
+			Message:            data,
+		}
+		if m[2] != "" {
+			dc, err := ParseDynamicChallenge(m[2])
+			if err != nil {
+				return nil, err
+			}
+			pe.DynamicChallenge = dc
+		}
+		return pe, nil
+	}
+	return nil, fmt.Errorf("invalid password request: %q", data)
+}
+
+// ParseDynamicChallenge parses CRV1:flags:state_id:base64_username:challenge_text
+func ParseDynamicChallenge(data string) (*DynamicChallenge, error) {
+	s := strings.SplitN(data, ":", 5)
+	if len(s) < 5 || s[0] != "CRV1" {
+		return nil, fmt.Errorf("invalid dynamic challenge: %q", data)
+	}
+	username, err := base64.StdEncoding.DecodeString(s[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid dynamic challenge username: %v", err)
+	}
+	return &DynamicChallenge{
+		Flags:    s[1],
+		StateID:  s[2],
+		Username: string(username),
+		Text:     s[4],
+	}, nil
+}
+
+func ParseNeed(data string) (*NeedEvent, error) {
+	m := needReg.FindStringSubmatch(data)
+	if m == nil {
+		return nil, fmt.Errorf("invalid need request: %q", data)
+	}
+	return &NeedEvent{Name: m[1], Message: m[2]}, nil
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var openVpnVersionReg = regexp.MustCompile(`^OpenVPN (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// LoadStats is the result of the load-stats command
+type LoadStats struct {
+	Clients  int
+	BytesIn  int64
+	BytesOut int64
+}
+
+// VersionInfo is the result of the version command, ManagementVersion is the
+// version of the management interface.
+type VersionInfo struct {
+	OpenVPN           string
+	Major             int
+	Minor             int
+	Patch             int
+	ManagementVersion int
+}
+
+// AtLeast reports if OpenVPN is at least version major.minor
+func (v *VersionInfo) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// SupportsPendingAuth reports if the management interface accepts
+// client-pending-auth with a key id and a timeout (version 5, OpenVPN 2.6)
+func (v *VersionInfo) SupportsPendingAuth() bool {
+	return v.ManagementVersion >= 5
+}
+
+// SupportsChallengeResponse reports if the management interface sends
+// >CLIENT:CR_RESPONSE (version 5, OpenVPN 2.6)
+func (v *VersionInfo) SupportsChallengeResponse() bool {
+	return v.ManagementVersion >= 5
+}
+
+// ParseLoadStats parses "nclients=N,bytesin=X,bytesout=Y"
+func ParseLoadStats(msg string) (*LoadStats, error) {
+	values := keyValues(msg)
+	clients, err := strconv.Atoi(values["nclients"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid load-stats: %q", msg)
+	}
+	in, err := strconv.ParseInt(values["bytesin"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load-stats: %q", msg)
+	}
+	out, err := strconv.ParseInt(values["bytesout"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load-stats: %q", msg)
+	}
+	return &LoadStats{Clients: clients, BytesIn: in, BytesOut: out}, nil
+}
+
+// ParsePid parses "pid=N"
+func ParsePid(msg string) (int, error) {
+	pid, err := strconv.Atoi(keyValues(msg)["pid"])
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid: %q", msg)
+	}
+	return pid, nil
+}
+
+// ParseVersion parses the lines of the version command, e.g.
+// "OpenVPN Version: OpenVPN 2.6.3 x86_64-pc-linux-gnu ..." and
+// "Management Version: 5"
+func ParseVersion(lines []string) (*VersionInfo, error) {
+	v := &VersionInfo{}
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch line[:i] {
+		case "OpenVPN Version":
+			v.OpenVPN = value
+			if m := openVpnVersionReg.FindStringSubmatch(value); m != nil {
+				v.Major, _ = strconv.Atoi(m[1])
+				v.Minor, _ = strconv.Atoi(m[2])
+				v.Patch, _ = strconv.Atoi(m[3])
+			}
+		case "Management Version":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid management version: %q", value)
+			}
+			v.ManagementVersion = n
+		}
+	}
+	if v.OpenVPN == "" {
+		return nil, fmt.Errorf("invalid version: %v", lines)
+	}
+	return v, nil
+}
+
+// keyValues parses comma separated key=value pairs
+func keyValues(msg string) map[string]string {
+	values := make(map[string]string)
+	for _, kv := range strings.Split(msg, ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			values[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+		}
+	}
+	return values
+}
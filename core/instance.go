@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrUnknownInstance = errors.New("unknown openvpn instance")
+
+// Instance is an OpenVPN daemon connected to a server mode connector, its ID
+// is the pid of the daemon when it answers the pid command.
+type Instance struct {
+	ID          string
+	Pid         int
+	Version     *VersionInfo
+	RemoteAddr  string
+	ConnectedAt time.Time
+}
+
+// InstanceLine is a line received from the instance with the ID Instance,
+// Instance is empty in client mode.
+type InstanceLine struct {
+	Instance string
+	Line     string
+}
+
+// InstanceConnector is implemented by connectors that accept several OpenVPN
+// instances, e.g. daemons started with --management-client for different
+// ports. Each instance is framed by ConnectionUp and ConnectionDown lines.
+type InstanceConnector interface {
+	OpenVpnConnector
+	// ListenInstances sends the lines received from every instance to lines
+	// until ctx is done or the connector is closed
+	ListenInstances(ctx context.Context, lines chan InstanceLine)
+	// SendCommandTo returns the response of command sent to an instance, the
+	// empty ID selects the instance SendCommand uses.
+	SendCommandTo(ctx context.Context, instance string, command string) (string, error)
+	// Instances returns the connected instances in the order they connected
+	Instances() []Instance
+}
+
+// identify asks a newly connected instance for its pid and version, they are
+// left unset if the instance answers with an error. The session is closed if
+// the instance does not answer within handshakeTimeout.
+func identify(s *session) (int, *VersionInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	var pid int
+	var version *VersionInfo
+	if resp, err := s.Send(ctx, "pid"); err == nil && resp.Err() == nil {
+		pid, _ = ParsePid(resp.Message)
+	}
+	if resp, err := s.Send(ctx, "version"); err == nil && resp.Err() == nil {
+		version, _ = ParseVersion(resp.Lines)
+	}
+	return pid, version
+}
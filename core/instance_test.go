@@ -0,0 +1,50 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestRegisterInstances(t *testing.T) {
+	c := newConnector("unix", "", "", ServerMode)
+	sessions := make([]*session, 3)
+	for i := range sessions {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		sessions[i] = newSession(client)
+	}
+	ids := []string{
+		c.register(sessions[0], 100, nil),
+		c.register(sessions[1], 100, nil),
+		c.register(sessions[2], 0, nil),
+	}
+	for i, expected := range []string{"100", "100-2", "instance-3"} {
+		if ids[i] != expected {
+			t.Errorf("Expected instance %s, got %s", expected, ids[i])
+		}
+	}
+	if instances := c.Instances(); len(instances) != 3 || instances[0].ID != "100" {
+		t.Errorf("Unexpected instances: %+v", instances)
+	}
+	if _, err := c.SendCommandTo(context.Background(), "200", "pid"); err != ErrUnknownInstance {
+		t.Errorf("Expected unknown instance, got %v", err)
+	}
+
+	// SendCommand falls back to the instance that connected last
+	c.unregister(ids[2])
+	if c.session != sessions[1] {
+		t.Error("Expected the session of instance 100-2")
+	}
+	c.unregister(ids[0])
+	c.unregister(ids[1])
+	if c.session != nil {
+		t.Error("Expected no session")
+	}
+	select {
+	case <-c.ready:
+		t.Error("Expected the connector not to be ready")
+	default:
+	}
+}
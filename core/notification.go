@@ -0,0 +1,111 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flags of >LOG: notifications
+const (
+	LogInformational = "I"
+	LogFatal         = "F"
+	LogNonFatal      = "N"
+	LogWarning       = "W"
+	LogDebug         = "D"
+)
+
+var managementVersionReg = regexp.MustCompile(`Management Interface Version (\d+)`)
+
+// LogEvent is a parsed >LOG: notification or a line of the log history
+type LogEvent struct {
+	Time    time.Time
+	Flags   string
+	Message string
+}
+
+// HasFlag reports if the log line has flag (e.g. LogWarning)
+func (l LogEvent) HasFlag(flag string) bool {
+	return strings.Contains(l.Flags, flag)
+}
+
+// EchoEvent is a parsed >ECHO: notification sent for echo directives in the config
+type EchoEvent struct {
+	Time  time.Time
+	Param string
+}
+
+// FatalEvent is sent before OpenVPN exits with a fatal error
+type FatalEvent struct {
+	Message string
+}
+
+// InfoEvent is an informational message, the first one sent after connecting
+// contains the management interface version.
+type InfoEvent struct {
+	Message           string
+	ManagementVersion int
+}
+
+// HoldEvent is sent when OpenVPN waits for a hold release, WaitSeconds is
+// only reported by newer versions.
+type HoldEvent struct {
+	Message     string
+	WaitSeconds int
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %q", s)
+	}
+	return time.Unix(ts, 0), nil
+}
+
+// ParseLog parses a log line: time,flags,message
+func ParseLog(data string) (*LogEvent, error) {
+	s := strings.SplitN(data, ",", 3)
+	if len(s) < 3 {
+		return nil, fmt.Errorf("invalid log: %q", data)
+	}
+	ts, err := parseTimestamp(s[0])
+	if err != nil {
+		return nil, err
+	}
+	return &LogEvent{Time: ts, Flags: s[1], Message: s[2]}, nil
+}
+
+// ParseEcho parses an echo line: time,param
+func ParseEcho(data string) (*EchoEvent, error) {
+	s := strings.SplitN(data, ",", 2)
+	if len(s) < 2 {
+		return nil, fmt.Errorf("invalid echo: %q", data)
+	}
+	ts, err := parseTimestamp(s[0])
+	if err != nil {
+		return nil, err
+	}
+	return &EchoEvent{Time: ts, Param: s[1]}, nil
+}
+
+func ParseInfo(data string) *InfoEvent {
+	info := &InfoEvent{Message: data}
+	if m := managementVersionReg.FindStringSubmatch(data); m != nil {
+		info.ManagementVersion, _ = strconv.Atoi(m[1])
+	}
+	return info
+}
+
+// ParseHold parses "Waiting for hold release[:seconds]"
+func ParseHold(data string) *HoldEvent {
+	hold := &HoldEvent{Message: data}
+	if i := strings.LastIndex(data, ":"); i >= 0 {
+		if n, err := strconv.Atoi(data[i+1:]); err == nil {
+			hold.Message = data[:i]
+			hold.WaitSeconds = n
+		}
+	}
+	return hold
+}
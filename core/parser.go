@@ -2,23 +2,15 @@ package core
 
 import (
 	"bytes"
-	"errors"
+	"encoding/base64"
+	"fmt"
 	"github.com/golang/glog"
-	"github.com/mungaij83/go-openvpn/utils"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
 var (
-	ClientListReg, _ = regexp.Compile("(?ms)OpenVPN CLIENT LIST\n" +
-		"Updated,([^\n]*)\n" +
-		"(.*)\n" +
-		"ROUTING TABLE\n" +
-		"(.*)\n" +
-		"GLOBAL STATS\n" +
-		"(.*)" +
-		"\nEND\n")
 	ClientEnv, _ = regexp.Compile("([^=\r\n]+)=([^\r\n]*)")
 )
 
@@ -31,6 +23,10 @@ type EventData struct {
 	Invalid   bool
 	Data      map[string]string
 	EventData string
+	Payload   interface{}
+	// Instance is the ID of the OpenVPN instance that sent the event when
+	// several connect in server mode, see InstanceConnector
+	Instance string
 }
 
 func (ed EventData) EventName() string {
@@ -46,6 +42,84 @@ func (ed *EventData) Merge(data EventData) {
 	}
 }
 
+// State returns the parsed >STATE: notification
+func (ed EventData) State() (*StateEvent, bool) {
+	st, ok := ed.Payload.(*StateEvent)
+	return st, ok
+}
+
+// Log returns the parsed >LOG: notification
+func (ed EventData) Log() (*LogEvent, bool) {
+	l, ok := ed.Payload.(*LogEvent)
+	return l, ok
+}
+
+// Echo returns the parsed >ECHO: notification
+func (ed EventData) Echo() (*EchoEvent, bool) {
+	e, ok := ed.Payload.(*EchoEvent)
+	return e, ok
+}
+
+// Fatal returns the parsed >FATAL: notification
+func (ed EventData) Fatal() (*FatalEvent, bool) {
+	f, ok := ed.Payload.(*FatalEvent)
+	return f, ok
+}
+
+// Info returns the parsed >INFO: notification
+func (ed EventData) Info() (*InfoEvent, bool) {
+	i, ok := ed.Payload.(*InfoEvent)
+	return i, ok
+}
+
+// Hold returns the parsed >HOLD: notification
+func (ed EventData) Hold() (*HoldEvent, bool) {
+	h, ok := ed.Payload.(*HoldEvent)
+	return h, ok
+}
+
+// Password returns the parsed >PASSWORD: notification
+func (ed EventData) Password() (*PasswordEvent, bool) {
+	p, ok := ed.Payload.(*PasswordEvent)
+	return p, ok
+}
+
+// Need returns the parsed >NEED-OK: or >NEED-STR: notification
+func (ed EventData) Need() (*NeedEvent, bool) {
+	n, ok := ed.Payload.(*NeedEvent)
+	return n, ok
+}
+
+// Sign returns the parsed >PK_SIGN: or >RSA_SIGN: notification
+func (ed EventData) Sign() (*SignEvent, bool) {
+	se, ok := ed.Payload.(*SignEvent)
+	return se, ok
+}
+
+// Certificate returns the parsed >NEED-CERTIFICATE: notification
+func (ed EventData) Certificate() (*CertificateEvent, bool) {
+	ce, ok := ed.Payload.(*CertificateEvent)
+	return ce, ok
+}
+
+// Remote returns the parsed >REMOTE: notification
+func (ed EventData) Remote() (*RemoteEvent, bool) {
+	r, ok := ed.Payload.(*RemoteEvent)
+	return r, ok
+}
+
+// Proxy returns the parsed >PROXY: notification
+func (ed EventData) Proxy() (*ProxyEvent, bool) {
+	p, ok := ed.Payload.(*ProxyEvent)
+	return p, ok
+}
+
+// Status returns the parsed status block
+func (ed EventData) Status() (*Status, bool) {
+	st, ok := ed.Payload.(*Status)
+	return st, ok
+}
+
 func (ed EventData) Get(k string) string {
 	if len(ed.Data) > 0 {
 		val, ok := ed.Data[k]
@@ -56,16 +130,23 @@ func (ed EventData) Get(k string) string {
 	return ""
 }
 
+// GetInt returns the value of k as an integer, or -1 if missing or invalid
+func (ed EventData) GetInt(k string) int {
+	n, err := strconv.Atoi(ed.Get(k))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
 type CommandParser struct {
-	ClientListReg *regexp.Regexp
-	buffer        *EventData
-	dataBuffer    *bytes.Buffer
+	buffer     *EventData
+	dataBuffer *bytes.Buffer
 }
 
 func NewCommandParser() CommandParser {
 	return CommandParser{
-		dataBuffer:    bytes.NewBufferString(""),
-		ClientListReg: ClientListReg,
+		dataBuffer: bytes.NewBufferString(""),
 	}
 }
 
@@ -81,14 +162,19 @@ func (cp CommandParser) Join(evt []string, start int, sep string) string {
 }
 
 func (cp *CommandParser) ParseEvent(evt string) *EventData {
+	// A block interrupted by a lost connection is never completed
+	if evt == ConnectionUp || evt == ConnectionDown {
+		cp.Reset()
+	}
 	// Handle listing events
-	if cp.buffer != nil && cp.buffer.Event == "CLIENT_LIST" {
-		cp.dataBuffer.WriteString(evt)
+	if cp.buffer != nil && (cp.buffer.Event == "CLIENT_LIST" || cp.buffer.Event == "STATUS") {
+		cp.writeLine(evt)
 		if strings.Compare(strings.TrimSpace(evt), "END") == 0 {
 			cp.buffer.EventData = cp.dataBuffer.String()
 			cp.dataBuffer.Reset()
 			dt := *cp.buffer
 			cp.buffer = nil
+			cp.parseStatusEvent(&dt)
 			return &dt
 		}
 		return nil
@@ -97,46 +183,157 @@ func (cp *CommandParser) ParseEvent(evt string) *EventData {
 	dt := EventData{
 		Data: make(map[string]string),
 	}
-	el := strings.Split(evt, ":")
-	if strings.HasPrefix(evt, ">") {
+	if source, payload, ok := SplitNotification(evt); ok {
 		dt.Realtime = true
-		dt.Event = strings.TrimPrefix(el[0], ">")
-		dt.EventData = cp.Join(el, 1, ":")
+		dt.Event = source
+		dt.EventData = payload
 	} else if strings.HasPrefix(evt, "OpenVPN") {
 		dt.Event = "CLIENT_LIST"
 		dt.HasEnd = true
 		dt.EventData = strings.TrimSuffix(evt, "OpenVPN CLIENT LIST")
+	} else if IsStatusTitle(evt) {
+		dt.Event = "STATUS"
 	} else {
-		dt.Event = el[0]
-		dt.EventData = cp.Join(el, 1, ":")
+		dt.Event, dt.EventData = splitFirst(evt, ":")
 	}
 
 	// Add type
 	switch dt.Event {
 	case "CLIENT":
-		typed := strings.Split(el[1], ",")
-		dt.EventType = typed[0]
-		dt.EventData = cp.Join(typed, 1, ",")
+		dt.EventType, dt.EventData = splitFirst(dt.EventData, ",")
 		err := cp.ParseClient(&dt)
 		if err != nil {
 			glog.Error(err)
 		}
 		break
 	case "BYTECOUNT":
-		s := strings.Split(dt.EventData, ",")
+		s, ok := SplitFields(dt.EventData, 2)
+		if !ok {
+			glog.Errorf("Invalid bytecount: %q", dt.EventData)
+			dt.Invalid = true
+			break
+		}
 		dt.Data["bytes_in"] = s[0]
 		dt.Data["bytes_out"] = s[1]
 		dt.Completed = true
 		break
 	case "BYTECOUNT_CLI":
-		s := strings.Split(dt.EventData, ",")
+		s, ok := SplitFields(dt.EventData, 3)
+		if !ok {
+			glog.Errorf("Invalid bytecount: %q", dt.EventData)
+			dt.Invalid = true
+			break
+		}
 		dt.Data["client_id"] = s[0]
 		dt.Data["bytes_in"] = s[1]
 		dt.Data["bytes_out"] = s[2]
 		dt.Completed = true
 		break
-	case "CLIENT_LIST":
-		cp.dataBuffer.WriteString(evt)
+	case "STATE":
+		st, err := ParseState(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Data["state"] = st.State
+		dt.Data["description"] = st.Description
+		dt.Payload = st
+		dt.Completed = true
+		break
+	case "LOG":
+		l, err := ParseLog(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Data["flags"] = l.Flags
+		dt.Payload = l
+		dt.Completed = true
+		break
+	case "ECHO":
+		e, err := ParseEcho(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Payload = e
+		dt.Completed = true
+		break
+	case "FATAL":
+		dt.Payload = &FatalEvent{Message: dt.EventData}
+		dt.Completed = true
+		break
+	case "INFO":
+		dt.Payload = ParseInfo(dt.EventData)
+		dt.Completed = true
+		break
+	case "HOLD":
+		dt.Payload = ParseHold(dt.EventData)
+		dt.Completed = true
+		break
+	case "PASSWORD":
+		p, err := ParsePassword(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Payload = p
+		dt.Completed = true
+		break
+	case "NEED-OK", "NEED-STR":
+		n, err := ParseNeed(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Payload = n
+		dt.Completed = true
+		break
+	case "PK_SIGN", "RSA_SIGN":
+		se, err := ParseSign(dt.EventData, dt.Event == "RSA_SIGN")
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Payload = se
+		dt.Completed = true
+		break
+	case "NEED-CERTIFICATE":
+		dt.Payload = &CertificateEvent{Hint: dt.EventData}
+		dt.Completed = true
+		break
+	case "REMOTE":
+		r, err := ParseRemote(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Payload = r
+		dt.Completed = true
+		break
+	case "PROXY":
+		p, err := ParseProxy(dt.EventData)
+		if err != nil {
+			glog.Error(err)
+			dt.Invalid = true
+			break
+		}
+		dt.Payload = p
+		dt.Completed = true
+		break
+	case "MANAGEMENT":
+		dt.EventType = dt.EventData
+		dt.Completed = true
+		break
+	case "CLIENT_LIST", "STATUS":
+		cp.writeLine(evt)
 		dt.HasEnd = true
 		break
 	default:
@@ -163,18 +360,54 @@ func (cp *CommandParser) ParseEvent(evt string) *EventData {
 	}
 	return nil
 }
+
+// Reset discards a partially parsed event
+func (cp *CommandParser) Reset() {
+	cp.buffer = nil
+	cp.dataBuffer.Reset()
+}
+
+// writeLine buffers a line of a multi-line block, lines read from the
+// connection have no line ending
+func (cp *CommandParser) writeLine(line string) {
+	cp.dataBuffer.WriteString(strings.TrimSuffix(line, "\n"))
+	cp.dataBuffer.WriteString("\n")
+}
+
+// parseStatusEvent parses a buffered status block, other blocks starting
+// with "OpenVPN" (e.g. the version) are returned as they are
+func (cp *CommandParser) parseStatusEvent(data *EventData) {
+	st, err := ParseStatus(data.EventData)
+	if err != nil {
+		if data.Event == "STATUS" {
+			glog.Error(err)
+			data.Invalid = true
+		} else {
+			glog.V(2).Infof("Not a status block: %v", err)
+		}
+		return
+	}
+	data.Payload = st
+	data.Completed = true
+}
+
 func (cp *CommandParser) ParseClient(data *EventData) error {
 	switch data.EventType {
 	case "ENV":
 		if data.EventData == "END" {
 			data.Completed = true
-		} else {
-			s := strings.Split(data.EventData, "=")
-			data.Data[s[0]] = s[1]
+		} else if k, v, _ := SplitKeyValue(data.EventData); k != "" {
+			data.Data[k] = v
 		}
 		break
 	case "ADDRESS":
-		s := strings.Split(data.EventData, ",")
+		s, ok := SplitFields(data.EventData, 3)
+		if !ok {
+			data.Invalid = true
+			return fmt.Errorf("invalid client address: %q", data.EventData)
+		}
+		// ADDRESS is a single line, it is not followed by ENV lines
+		data.Completed = true
 		data.Data["client_id"] = s[0]
 		data.Data["client_address"] = s[1]
 		data.Data["primary_address"] = s[2]
@@ -187,16 +420,41 @@ func (cp *CommandParser) ParseClient(data *EventData) error {
 		data.Data["client_id"] = data.EventData
 		break
 	case "REAUTH":
-		s := strings.Split(data.EventData, ",")
+		s, ok := SplitFields(data.EventData, 2)
+		if !ok {
+			data.Invalid = true
+			return fmt.Errorf("invalid client reauth: %q", data.EventData)
+		}
 		data.Data["client_id"] = s[0]
 		data.Data["client_key_id"] = s[1]
+		data.Data["key_id"] = s[1]
 		data.HasEnd = true
 		break
 	case "CONNECT":
+		s, ok := SplitFields(data.EventData, 2)
+		if !ok {
+			data.Invalid = true
+			return fmt.Errorf("invalid client connect: %q", data.EventData)
+		}
+		data.HasEnd = true
+		data.Data["client_id"] = s[0]
+		data.Data["key_id"] = s[1]
+		break
+	case "CR_RESPONSE":
 		data.HasEnd = true
-		s := strings.Split(data.EventData, ",")
+		s, ok := SplitFields(data.EventData, 3)
+		if !ok {
+			data.Invalid = true
+			return fmt.Errorf("invalid challenge response: %q", data.EventData)
+		}
+		response, err := base64.StdEncoding.DecodeString(s[2])
+		if err != nil {
+			data.Invalid = true
+			return fmt.Errorf("invalid challenge response encoding: %v", err)
+		}
 		data.Data["client_id"] = s[0]
 		data.Data["key_id"] = s[1]
+		data.Data["cr_response"] = string(response)
 		break
 	default:
 		data.Invalid = true
@@ -206,64 +464,7 @@ func (cp *CommandParser) ParseClient(data *EventData) error {
 	return nil
 }
 
-func (cp *CommandParser) ParseStatus(out string) ([]utils.Client, error) {
-	match := cp.ClientListReg.FindAllStringSubmatch(out, -1)
-	if len(match) == 0 {
-		return nil, errors.New("no client found")
-	}
-	for ix, il := range match {
-		glog.V(2).Infof("Index[%d]: %v", ix, il)
-	}
-	return cp.clientList(match[0][2])
-}
-
-func (cp *CommandParser) clientList(match string) ([]utils.Client, error) { // {{{
-	if len(match) < 3 {
-		glog.Errorf("Invalid client list, regexp failed: %v", match)
-		return nil, errors.New("invalid client list")
-	}
-	clients := cp.makeCsvList(match)
-
-	clientsList := make([]utils.Client, len(clients))
-	for _, c := range clients {
-		cc := utils.Client{
-			CommonName:   c["Common Name"],
-			PublicIP:     c["Real Address"],
-			PrivateIP:    c["Virtual Address"],
-			BytesRecived: cp.parseInt64(c["Bytes Received"]),
-			BytesSent:    cp.parseInt64(c["Bytes Sent"]),
-			LastRef:      c["Last Ref"],
-			Env:          nil,
-		}
-		clientsList = append(clientsList, cc)
-	}
-	return clientsList, nil
-}
-
-func (CommandParser) parseInt64(d string) int64 {
-	n, err := strconv.ParseInt(d, 10, 64)
-	if err != nil {
-		return 0
-	}
-	return n
-}
-
-
-func (CommandParser) makeCsvList(data string) (list []map[string]string) { // {{{
-	list = make([]map[string]string, 0)
-
-	rows := strings.Split(data, "\n")
-
-	cols := strings.Split(rows[0], ",")
-
-	for i, row := range rows[1:] {
-		values := strings.Split(row, ",")
-
-		list = append(list, make(map[string]string, 0))
-
-		for c, col := range cols {
-			list[i][col] = values[c]
-		}
-	}
-	return
+// ParseStatus parses the output of any status version
+func (cp *CommandParser) ParseStatus(out string) (*Status, error) {
+	return ParseStatus(out)
 }
@@ -2,8 +2,10 @@ package core
 
 import (
 	"flag"
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 var parser CommandParser
@@ -44,22 +46,50 @@ func TestParseClients(t *testing.T) {
 		"GLOBAL STATS\n",
 		"Max bcast/mcast queue length,0\n",
 		"END\n"}
+	// The CLIENT block of TestCLientLine is left open in the shared parser
+	parser := NewCommandParser()
 	var evt *EventData
-	for _,t:=range out {
-		evt=parser.ParseEvent(t)
-		if evt!=nil {
+	for _, l := range out {
+		evt = parser.ParseEvent(l)
+		if evt != nil {
 			break
 		}
 	}
-	if evt==nil{
+	if evt == nil {
 		t.Fatal("Failed to parse output")
-	} else {
-		t.Logf("EVENT: %+v", evt)
-		clients, err := parser.ParseStatus(evt.EventData)
-		if err != nil {
-			t.Fatal(err)
-		} else {
-			t.Logf("Clients: %+v", clients)
+	}
+	t.Logf("EVENT: %+v", evt)
+	st, err := parser.ParseStatus(evt.EventData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Clients) != 1 {
+		t.Fatalf("Expected 1 client, got %+v", st.Clients)
+	}
+	c := st.Clients[0]
+	if c.CommonName != "VPN_client" || c.VirtualAddress != "192.168.11.4" || c.BytesReceived != 12563 ||
+		c.LastRef.IsZero() || c.ConnectedSince.IsZero() || st.Updated.IsZero() {
+		t.Errorf("Invalid client: %+v", c)
+	}
+	if st.GlobalStats["Max bcast/mcast queue length"] != "0" {
+		t.Errorf("Invalid global stats: %v", st.GlobalStats)
+	}
+	if payload, ok := evt.Status(); !ok || len(payload.Clients) != 1 {
+		t.Errorf("Missing status payload: %+v", evt)
+	}
+}
+
+func TestParseStatusErrors(t *testing.T) {
+	for _, out := range []string{
+		"OpenVPN CLIENT LIST\nCommon Name,Real Address,Bytes Received\nc1,1.2.3.4:1194\nEND\n",
+		"OpenVPN CLIENT LIST\nCommon Name,Real Address,Bytes Received\nc1,1.2.3.4:1194,many\nEND\n",
+		"OpenVPN STATISTICS\nTUN/TAP read bytes,x\nEND\n",
+		"TITLE,OpenVPN\nTIME,now\nEND\n",
+		"CLIENT_LIST,c1\n",
+	} {
+		_, err := ParseStatus(out)
+		if _, ok := err.(*StatusError); !ok {
+			t.Errorf("Expected StatusError for %q, got %v", out, err)
 		}
 	}
 }
@@ -126,4 +156,185 @@ func TestParseClients(t *testing.T) {
 //	close(done)
 //
 //	waitGroup.Wait()
-//}
\ No newline at end of file
+//}
+func TestParseState(t *testing.T) {
+	p := NewCommandParser()
+	evt := p.ParseEvent(">STATE:1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,192.168.1.2,51234,fd00::1000")
+	if evt == nil {
+		t.Fatal("Failed to parse state")
+	}
+	st, ok := evt.State()
+	if !ok {
+		t.Fatalf("Missing state payload: %+v", evt)
+	}
+	if st.State != StateConnected || st.LocalIP != "10.8.0.6" || st.RemotePort != 1194 ||
+		st.LocalPort != 51234 || st.LocalIPv6 != "fd00::1000" || st.Time.Unix() != 1392336000 {
+		t.Errorf("Invalid state: %+v", st)
+	}
+}
+
+func TestParseNotifications(t *testing.T) {
+	p := NewCommandParser()
+	evt := p.ParseEvent(">LOG:1392336000,W,WARNING: route gateway 10.0.0.1: not found, skipping")
+	if l, ok := evt.Log(); !ok || !l.HasFlag(LogWarning) || l.Message != "WARNING: route gateway 10.0.0.1: not found, skipping" {
+		t.Errorf("Invalid log: %+v", evt)
+	}
+	evt = p.ParseEvent(">ECHO:1101519562,forget-passwords")
+	if e, ok := evt.Echo(); !ok || e.Param != "forget-passwords" {
+		t.Errorf("Invalid echo: %+v", evt)
+	}
+	evt = p.ParseEvent(">FATAL:Cannot open TUN/TAP dev /dev/net/tun")
+	if f, ok := evt.Fatal(); !ok || f.Message != "Cannot open TUN/TAP dev /dev/net/tun" {
+		t.Errorf("Invalid fatal: %+v", evt)
+	}
+	evt = p.ParseEvent(">INFO:OpenVPN Management Interface Version 5 -- type 'help' for more info")
+	if i, ok := evt.Info(); !ok || i.ManagementVersion != 5 {
+		t.Errorf("Invalid info: %+v", evt)
+	}
+	evt = p.ParseEvent(">HOLD:Waiting for hold release:10")
+	if h, ok := evt.Hold(); !ok || h.WaitSeconds != 10 || h.Message != "Waiting for hold release" {
+		t.Errorf("Invalid hold: %+v", evt)
+	}
+	evt = p.ParseEvent(">HOLD:Waiting for hold release")
+	if h, ok := evt.Hold(); !ok || h.WaitSeconds != 0 {
+		t.Errorf("Invalid hold: %+v", evt)
+	}
+}
+
+func TestParseStatus2(t *testing.T) {
+	out := []string{
+		"TITLE,OpenVPN 2.6.3 x86_64-pc-linux-gnu [SSL (OpenSSL)] [LZO] [LZ4] [EPOLL] [MH/PKTINFO] [AEAD]",
+		"TIME,Thu Feb 13 23:39:20 2014,1392334760",
+		"HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username,Client ID,Peer ID,Data Channel Cipher",
+		"CLIENT_LIST,client1,10.13.156.4:51234,10.8.0.6,fd00::1000,3860,3696,Thu Feb 13 23:30:00 2014,1392334200,alice,4,1,AES-256-GCM",
+		"HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)",
+		"ROUTING_TABLE,10.8.0.6,client1,10.13.156.4:51234,Thu Feb 13 23:39:10 2014,1392334750",
+		"GLOBAL_STATS,Max bcast/mcast queue length,0",
+		"END",
+	}
+	p := NewCommandParser()
+	var evt *EventData
+	for _, l := range out {
+		evt = p.ParseEvent(l)
+	}
+	if evt == nil {
+		t.Fatal("Failed to parse status")
+	}
+	st, ok := evt.Status()
+	if !ok {
+		t.Fatalf("Missing status payload: %+v", evt)
+	}
+	if st.Updated.Unix() != 1392334760 || len(st.Clients) != 1 || len(st.Routes) != 1 {
+		t.Fatalf("Invalid status: %+v", st)
+	}
+	c := st.Clients[0]
+	if c.CommonName != "client1" || c.VirtualIPv6Address != "fd00::1000" || c.BytesReceived != 3860 ||
+		c.Username != "alice" || c.ClientID != 4 || c.PeerID != 1 || c.DataChannelCipher != "AES-256-GCM" ||
+		c.ConnectedSince.Unix() != 1392334200 {
+		t.Errorf("Invalid client: %+v", c)
+	}
+	if st.Routes[0].LastRef.Unix() != 1392334750 || st.GlobalStats["Max bcast/mcast queue length"] != "0" {
+		t.Errorf("Invalid status: %+v", st)
+	}
+}
+
+func TestParseStatus3(t *testing.T) {
+	out := "TITLE\tOpenVPN 2.6.3\n" +
+		"TIME\tThu Feb 13 23:39:20 2014\t1392334760\n" +
+		"HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tVirtual IPv6 Address\tBytes Received\tBytes Sent\tConnected Since\tConnected Since (time_t)\tUsername\tClient ID\tPeer ID\tData Channel Cipher\n" +
+		"CLIENT_LIST\tDoe, John\t10.13.156.4:51234\t10.8.0.6\t\t3860\t3696\tThu Feb 13 23:30:00 2014\t1392334200\tUNDEF\t4\t1\tAES-256-GCM\n" +
+		"HEADER\tROUTING_TABLE\tVirtual Address\tCommon Name\tReal Address\tLast Ref\tLast Ref (time_t)\n" +
+		"GLOBAL_STATS\tMax bcast/mcast queue length\t0\n" +
+		"END\n"
+	st, err := ParseStatus3(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Clients) != 1 || st.Clients[0].CommonName != "Doe, John" || st.Clients[0].BytesSent != 3696 {
+		t.Errorf("Invalid status: %+v", st)
+	}
+	if _, err = ParseStatus3("CLIENT_LIST\tx\nEND\n"); err == nil {
+		t.Error("Expected error for row without header")
+	}
+}
+
+func TestParseStatus1(t *testing.T) {
+	out := "OpenVPN STATISTICS\n" +
+		"Updated,Thu Feb 13 23:39:20 2014\n" +
+		"TUN/TAP read bytes,153789941\n" +
+		"TUN/TAP write bytes,308764078\n" +
+		"TCP/UDP read bytes,292806201\n" +
+		"TCP/UDP write bytes,184633797\n" +
+		"Auth read bytes,308764078\n" +
+		"pre-compress bytes,45388190\n" +
+		"post-compress bytes,45446864\n" +
+		"pre-decompress bytes,162596168\n" +
+		"post-decompress bytes,216965355\n" +
+		"END\n"
+	st, err := ParseStatus1(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := st.Statistics
+	if cs == nil || cs.TunReadBytes != 153789941 || cs.LinkWriteBytes != 184633797 ||
+		cs.AuthReadBytes != 308764078 || cs.PostDecompressBytes != 216965355 {
+		t.Errorf("Invalid statistics: %+v", cs)
+	}
+	if st.Updated.Year() != 2014 || len(st.Clients) != 0 {
+		t.Errorf("Invalid status: %+v", st)
+	}
+
+	st, err = ParseStatus1("OpenVPN CLIENT LIST\n" +
+		"Updated,Thu Feb 13 23:39:20 2014\n" +
+		"Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since\n" +
+		"VPN_client,10.13.156.4:1194,12563,14885,Thu Feb 13 23:39:20 2014\n" +
+		"ROUTING TABLE\n" +
+		"Virtual Address,Common Name,Real Address,Last Ref\n" +
+		"192.168.11.4,VPN_client,10.13.156.4:1194,Thu Feb 13 23:39:20 2014\n" +
+		"GLOBAL STATS\n" +
+		"Max bcast/mcast queue length,0\n" +
+		"END\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Statistics != nil || len(st.Clients) != 1 || st.Clients[0].BytesSent != 14885 || len(st.Routes) != 1 {
+		t.Errorf("Invalid status: %+v", st)
+	}
+	if _, err = ParseStatus1("SUCCESS: pid=1\n"); err == nil {
+		t.Error("Expected error for invalid status")
+	}
+}
+
+func TestParseStatus1ISO(t *testing.T) {
+	out, err := ioutil.ReadFile("testdata/status1-2.6.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := ParseStatus(string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Date(2024, 3, 12, 9, 41, 7, 0, time.Local)
+	if !st.Updated.Equal(updated) || len(st.Clients) != 2 || st.GlobalStats["dco_enabled"] != "0" {
+		t.Fatalf("Invalid status: %+v", st)
+	}
+	c := st.Clients[0]
+	if !c.ConnectedSince.Equal(time.Date(2024, 3, 12, 9, 12, 55, 0, time.Local)) ||
+		!c.LastRef.Equal(time.Date(2024, 3, 12, 9, 41, 2, 0, time.Local)) || c.VirtualAddress != "10.8.0.6" {
+		t.Errorf("Invalid client: %+v", c)
+	}
+}
+
+func TestParseConnectionEvents(t *testing.T) {
+	p := NewCommandParser()
+	p.ParseEvent(">CLIENT:CONNECT,1,0")
+	p.ParseEvent(">CLIENT:ENV,common_name=client1")
+	evt := p.ParseEvent(ConnectionDown)
+	if evt == nil || evt.EventName() != "MANAGEMENT_DISCONNECTED" {
+		t.Fatalf("Unexpected event: %+v", evt)
+	}
+	evt = p.ParseEvent(">BYTECOUNT:3,5")
+	if evt == nil || evt.EventName() != "BYTECOUNT" || len(evt.Data) != 2 {
+		t.Errorf("Interrupted block was not discarded: %+v", evt)
+	}
+}
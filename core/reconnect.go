@@ -0,0 +1,225 @@
+package core
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"sync"
+	"time"
+)
+
+// Lines sent on the events channel by ReconnectingConnector when the
+// connection goes up or down, and by server mode connectors when an instance
+// connects or disconnects. They are not part of the OpenVPN protocol.
+const (
+	ConnectionUp   = ">MANAGEMENT:CONNECTED"
+	ConnectionDown = ">MANAGEMENT:DISCONNECTED"
+)
+
+const (
+	DefaultMinBackoff = time.Millisecond * 500
+	DefaultMaxBackoff = time.Second * 30
+)
+
+// ReconnectingConnector is a client mode connector that dials OpenVPN again
+// when the connection is lost, e.g. after a restart. The delay between
+// attempts doubles from MinBackoff up to MaxBackoff. Commands (e.g.
+// "state on", "bytecount 5") are sent after every connect.
+type ReconnectingConnector struct {
+	Commands   []string
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	network    string
+	address    string
+	password   string
+	lock       sync.Mutex
+	current    *connector
+	tap        Tap
+	shutdown   chan bool
+	stopOnce   sync.Once
+	routines   sync.WaitGroup
+}
+
+// NewReconnectingConnector returns a connector to the management interface
+// listening on address, network is "tcp" or "unix".
+func NewReconnectingConnector(network, address, password string, commands ...string) *ReconnectingConnector {
+	return &ReconnectingConnector{
+		Commands:   commands,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		network:    network,
+		address:    address,
+		password:   password,
+		shutdown:   make(chan bool),
+	}
+}
+
+// Connect dials until OpenVPN answers or ctx is done
+func (r *ReconnectingConnector) Connect(ctx context.Context) error {
+	backoff := r.MinBackoff
+	if backoff <= 0 {
+		backoff = DefaultMinBackoff
+	}
+	for {
+		err := r.connect(ctx)
+		if err == nil || err == ErrBadPassword || err == ErrConnectionClosed {
+			return err
+		}
+		glog.Warningf("Management connection to %s failed, retrying in %v: %v", r.address, backoff, err)
+		if !r.sleep(ctx, backoff) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrConnectionClosed
+		}
+		backoff = r.next(backoff)
+	}
+}
+
+// connect dials once and sends Commands
+func (r *ReconnectingConnector) connect(ctx context.Context) error {
+	c := newConnector(r.network, r.address, r.password, ClientMode)
+	r.lock.Lock()
+	c.SetTap(r.tap)
+	r.lock.Unlock()
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	select {
+	case <-r.shutdown:
+		r.lock.Unlock()
+		_ = c.Close()
+		return ErrConnectionClosed
+	default:
+	}
+	r.current = c
+	r.lock.Unlock()
+	for _, cmd := range r.Commands {
+		if _, err := c.SendCommand(ctx, cmd); err != nil {
+			glog.Warningf("Failed to send %q after connect: %v", cmd, err)
+		}
+	}
+	return nil
+}
+
+// SetTap records every connection made after it is called
+func (r *ReconnectingConnector) SetTap(tap Tap) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tap = tap
+}
+
+func (r *ReconnectingConnector) connection() *connector {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.current
+}
+
+func (r *ReconnectingConnector) SendCommand(ctx context.Context, command string) (string, error) {
+	c := r.connection()
+	if c == nil {
+		return "", ErrConnectionClosed
+	}
+	return c.SendCommand(ctx, command)
+}
+
+// Listen forwards the notifications of every connection to events, preceded
+// by ConnectionUp and followed by ConnectionDown.
+func (r *ReconnectingConnector) Listen(ctx context.Context, events chan string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	select {
+	case <-r.shutdown:
+		return
+	default:
+	}
+	r.routines.Add(1)
+	go func() {
+		defer r.routines.Done()
+		r.run(ctx, events)
+	}()
+}
+
+func (r *ReconnectingConnector) run(ctx context.Context, events chan string) {
+	for {
+		c := r.connection()
+		if c == nil {
+			return
+		}
+		if !r.emit(ctx, events, ConnectionUp) {
+			return
+		}
+		c.Listen(ctx, events)
+		select {
+		case <-c.session.closed:
+		case <-ctx.Done():
+			return
+		case <-r.shutdown:
+			return
+		}
+		_ = c.Close()
+		glog.Warningf("Management connection to %s lost", r.address)
+		if !r.emit(ctx, events, ConnectionDown) {
+			return
+		}
+		if err := r.Connect(ctx); err != nil {
+			glog.V(2).Infof("Reconnect stopped: %v", err)
+			return
+		}
+	}
+}
+
+// emit sends a connection event unless the connector is stopped
+func (r *ReconnectingConnector) emit(ctx context.Context, events chan string, line string) bool {
+	select {
+	case events <- line:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-r.shutdown:
+		return false
+	}
+}
+
+// sleep waits for d, it returns false if ctx is done or the connector is closed
+func (r *ReconnectingConnector) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-r.shutdown:
+		return false
+	}
+}
+
+func (r *ReconnectingConnector) next(backoff time.Duration) time.Duration {
+	max := r.MaxBackoff
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	backoff *= 2
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// Close closes the current connection and stops reconnecting, it may be
+// called more than once
+func (r *ReconnectingConnector) Close() error {
+	var err error
+	r.stopOnce.Do(func() {
+		r.lock.Lock()
+		close(r.shutdown)
+		c := r.current
+		r.lock.Unlock()
+		if c != nil {
+			err = c.Close()
+		}
+	})
+	r.routines.Wait()
+	return err
+}
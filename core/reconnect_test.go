@@ -0,0 +1,91 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconnectingConnector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "management.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Every connection receives the subscription command and is then dropped
+	commands := make(chan string, 10)
+	drop := make(chan bool)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(c)
+			line, _ := r.ReadString('\n')
+			commands <- line
+			_, _ = c.Write([]byte("SUCCESS: real-time state notification set to ON\n>STATE:1392336000,CONNECTED,SUCCESS,,,,,\n"))
+			<-drop
+			_ = c.Close()
+		}
+	}()
+
+	rc := NewReconnectingConnector("unix", socket, "", "state on")
+	rc.MinBackoff = time.Millisecond * 10
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err = rc.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string, 10)
+	rc.Listen(ctx, events)
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e != want {
+				t.Errorf("expected %q, got %q", want, e)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if cmd := <-commands; cmd != "state on\n" {
+			t.Errorf("unexpected command: %q", cmd)
+		}
+		expect(ConnectionUp)
+		expect(">STATE:1392336000,CONNECTED,SUCCESS,,,,,")
+		drop <- true
+		expect(ConnectionDown)
+	}
+	if err = rc.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = rc.Close(); err != nil {
+		t.Errorf("second close failed: %v", err)
+	}
+}
+
+func TestReconnectingConnectorTimeout(t *testing.T) {
+	rc := NewReconnectingConnector("unix", filepath.Join(os.TempDir(), "missing.sock"), "")
+	rc.MinBackoff = time.Millisecond * 5
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := rc.Connect(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// RemoteEvent is a parsed >REMOTE: notification sent with --management-query-remote
+type RemoteEvent struct {
+	Host     string
+	Port     int
+	Protocol string
+}
+
+// ProxyEvent is a parsed >PROXY: notification sent with --management-query-proxy
+type ProxyEvent struct {
+	Index    int
+	Protocol string
+	Host     string
+	Port     int
+}
+
+// ParseRemote parses host,port,protocol
+func ParseRemote(data string) (*RemoteEvent, error) {
+	s, ok := SplitFields(data, 3)
+	if !ok {
+		return nil, fmt.Errorf("invalid remote: %q", data)
+	}
+	port, err := strconv.Atoi(s[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote port: %q", s[1])
+	}
+	return &RemoteEvent{Host: s[0], Port: port, Protocol: s[2]}, nil
+}
+
+// ParseProxy parses index,protocol,host[,port]
+func ParseProxy(data string) (*ProxyEvent, error) {
+	// The port is optional, fields after it are ignored
+	s, _ := SplitFields(data, 5)
+	if s[2] == "" {
+		return nil, fmt.Errorf("invalid proxy: %q", data)
+	}
+	index, err := strconv.Atoi(s[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy index: %q", s[0])
+	}
+	pe := &ProxyEvent{Index: index, Protocol: s[1], Host: s[2]}
+	pe.Port, _ = strconv.Atoi(s[3])
+	return pe, nil
+}
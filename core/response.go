@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	ResponseSuccess = "SUCCESS"
+	ResponseError   = "ERROR"
+	ResponseEnd     = "END"
+)
+
+// Response is a single reply to a management command. Replies are either a
+// single SUCCESS:/ERROR: line or a block of lines terminated by END.
+type Response struct {
+	Status  string
+	Message string
+	Lines   []string
+}
+
+// CommandError is returned when OpenVPN answers a command with ERROR:
+type CommandError struct {
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("management command failed: %s", e.Message)
+}
+
+func (r Response) IsMultiLine() bool {
+	return r.Status == ""
+}
+
+// Err returns a *CommandError when the response is an ERROR: line
+func (r Response) Err() error {
+	if r.Status == ResponseError {
+		return &CommandError{Message: r.Message}
+	}
+	return nil
+}
+
+// String returns the response as it was received on the wire
+func (r Response) String() string {
+	if !r.IsMultiLine() {
+		return fmt.Sprintf("%s: %s", r.Status, r.Message)
+	}
+	b := strings.Builder{}
+	for _, l := range r.Lines {
+		b.WriteString(l)
+		b.WriteString("\n")
+	}
+	b.WriteString(ResponseEnd)
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ResponseReader frames command responses from the management stream.
+// Real-time notifications (lines starting with '>') may arrive between or
+// before response lines, they are handed to Notify instead of the response.
+type ResponseReader struct {
+	reader *textproto.Reader
+	Notify func(line string)
+	// Trace, if set, receives every line read
+	Trace func(line string)
+}
+
+func NewResponseReader(reader *bufio.Reader, notify func(line string)) *ResponseReader {
+	return &ResponseReader{
+		reader: textproto.NewReader(reader),
+		Notify: notify,
+	}
+}
+
+// ReadLine reads the next raw line from the stream
+func (rr *ResponseReader) ReadLine() (string, error) {
+	line, err := rr.reader.ReadLine()
+	if err == nil && rr.Trace != nil {
+		rr.Trace(line)
+	}
+	return line, err
+}
+
+// ReadResponse reads until a complete response has been received
+func (rr *ResponseReader) ReadResponse() (*Response, error) {
+	var resp *Response
+	for {
+		line, err := rr.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, ">") {
+			if rr.Notify != nil {
+				rr.Notify(line)
+			}
+			continue
+		}
+		if resp == nil {
+			if status, msg, ok := parseStatusLine(line); ok {
+				return &Response{Status: status, Message: msg}, nil
+			}
+			resp = &Response{Lines: make([]string, 0)}
+		}
+		if line == ResponseEnd {
+			return resp, nil
+		}
+		resp.Lines = append(resp.Lines, line)
+	}
+}
+
+func parseStatusLine(line string) (string, string, bool) {
+	for _, status := range []string{ResponseSuccess, ResponseError} {
+		if strings.HasPrefix(line, status+":") {
+			return status, strings.TrimSpace(strings.TrimPrefix(line, status+":")), true
+		}
+	}
+	return "", "", false
+}
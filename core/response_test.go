@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSingleLineResponse(t *testing.T) {
+	notifications := make([]string, 0)
+	in := ">INFO:OpenVPN Management Interface Version 1 -- type 'help' for more info\n" +
+		"SUCCESS: pid=1234\n"
+	rr := NewResponseReader(bufio.NewReader(strings.NewReader(in)), func(line string) {
+		notifications = append(notifications, line)
+	})
+	resp, err := rr.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ResponseSuccess || resp.Message != "pid=1234" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(notifications) != 1 {
+		t.Errorf("expected 1 notification, got %v", notifications)
+	}
+}
+
+func TestReadErrorResponse(t *testing.T) {
+	rr := NewResponseReader(bufio.NewReader(strings.NewReader("ERROR: unknown command, enter 'help' for more options\n")), nil)
+	resp, err := rr.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Err() == nil {
+		t.Error("expected error response")
+	}
+}
+
+func TestReadMultiLineResponse(t *testing.T) {
+	notifications := make([]string, 0)
+	in := "OpenVPN CLIENT LIST\n" +
+		"Updated,Thu Feb 13 23:39:20 2014\n" +
+		">BYTECOUNT:3,5\n" +
+		"Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since\n" +
+		"END\n" +
+		"SUCCESS: bytecount interval changed\n"
+	rr := NewResponseReader(bufio.NewReader(strings.NewReader(in)), func(line string) {
+		notifications = append(notifications, line)
+	})
+	resp, err := rr.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsMultiLine() || len(resp.Lines) != 3 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if len(notifications) != 1 || notifications[0] != ">BYTECOUNT:3,5" {
+		t.Errorf("unexpected notifications: %v", notifications)
+	}
+	resp, err = rr.ReadResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ResponseSuccess {
+		t.Errorf("expected second response to be read separately: %+v", resp)
+	}
+}
+
+func TestSessionSend(t *testing.T) {
+	client, server := net.Pipe()
+	s := newSession(client)
+	go s.readLoop()
+	defer s.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		cmd, _ := r.ReadString('\n')
+		if cmd != "state all\n" {
+			t.Errorf("unexpected command: %q", cmd)
+		}
+		_, _ = server.Write([]byte(">LOG:1392336000,I,starting\n" +
+			"1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,\n" +
+			"END\n"))
+	}()
+	resp, err := s.Send(context.Background(), "state all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Lines) != 1 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	lines := make(chan InstanceLine, 1)
+	go s.forward("", lines)
+	if n := <-lines; n.Line != ">LOG:1392336000,I,starting" {
+		t.Errorf("unexpected notification: %v", n)
+	}
+}
+
+func TestSessionNotifyFull(t *testing.T) {
+	client, server := net.Pipe()
+	s := newSession(client)
+	go s.readLoop()
+	defer s.Close()
+	count := cap(s.notifications) * 2
+	go func() {
+		for i := 0; i < count; i++ {
+			_, _ = fmt.Fprintf(server, ">BYTECOUNT:%d,0\n", i)
+		}
+	}()
+	// The reader blocks on the full buffer until the lines are forwarded
+	for len(s.notifications) < cap(s.notifications) {
+		time.Sleep(time.Millisecond)
+	}
+	lines := make(chan InstanceLine)
+	go s.forward("", lines)
+	for i := 0; i < count; i++ {
+		if n := <-lines; n.Line != fmt.Sprintf(">BYTECOUNT:%d,0", i) {
+			t.Fatalf("expected notification %d, got %v", i, n)
+		}
+	}
+}
+
+func TestSessionSendOnAll(t *testing.T) {
+	client, server := net.Pipe()
+	s := newSession(client)
+	go s.readLoop()
+	defer s.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		_, _ = r.ReadString('\n')
+		_, _ = server.Write([]byte("SUCCESS: real-time state notification set to ON\n" +
+			"1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,\n" +
+			"END\n"))
+		_, _ = r.ReadString('\n')
+		_, _ = server.Write([]byte("SUCCESS: pid=1\n"))
+	}()
+	resp, err := s.Send(context.Background(), "state on all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Lines) != 1 {
+		t.Errorf("expected the history, got %+v", resp)
+	}
+	if resp, err = s.Send(context.Background(), "pid"); err != nil || resp.Message != "pid=1" {
+		t.Errorf("unexpected response %+v: %v", resp, err)
+	}
+}
+
+func TestSessionSendCancel(t *testing.T) {
+	client, server := net.Pipe()
+	s := newSession(client)
+	go s.readLoop()
+	defer s.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		_, _ = r.ReadString('\n')
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := s.Send(ctx, "pid"); err != context.DeadlineExceeded {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	// The response to pid would be taken for the response to load-stats
+	if resp, err := s.Send(context.Background(), "load-stats"); err != ErrConnectionClosed {
+		t.Errorf("expected the session to be closed, got %+v: %v", resp, err)
+	}
+}
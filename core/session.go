@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrConnectionClosed = errors.New("management connection closed")
+
+// session is a connection to the OpenVPN management interface that is used
+// to send commands and wait for their response. A single reader goroutine
+// splits the stream into command responses and real-time notifications.
+// Responses are matched to commands in the order the commands were written.
+type session struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	writeLock     sync.Mutex
+	waitersLock   sync.Mutex
+	waiters       []chan *Response
+	notifications chan string
+	closed        chan bool
+	closeOnce     sync.Once
+	tap           Tap
+	instanceLock  sync.Mutex
+	instance      string
+}
+
+func newSession(c net.Conn) *session {
+	return &session{
+		conn:          c,
+		reader:        bufio.NewReader(c),
+		waiters:       make([]chan *Response, 0),
+		notifications: make(chan string, 100),
+		closed:        make(chan bool),
+	}
+}
+
+// authenticate answers the password prompt, it must be called before readLoop
+func (s *session) authenticate(ctx context.Context, password string) error {
+	return authenticate(ctx, s.conn, s.reader, password)
+}
+
+// readLoop reads from the connection until it is closed
+func (s *session) readLoop() {
+	rr := NewResponseReader(s.reader, s.notify)
+	if s.tap != nil {
+		rr.Trace = func(line string) {
+			s.record(DirectionRead, line)
+		}
+	}
+	for {
+		resp, err := rr.ReadResponse()
+		if err != nil {
+			glog.V(2).Infof("Management connection closed: %v", err)
+			s.shutdown()
+			return
+		}
+		s.waitersLock.Lock()
+		if len(s.waiters) == 0 {
+			s.waitersLock.Unlock()
+			glog.Warningf("Unexpected response dropped: %v", resp)
+			continue
+		}
+		waiter := s.waiters[0]
+		s.waiters = s.waiters[1:]
+		s.waitersLock.Unlock()
+		waiter <- resp
+	}
+}
+
+// notify blocks until line is forwarded or the session is closed, a slow
+// reader holds back the responses rather than losing protocol lines.
+func (s *session) notify(line string) {
+	select {
+	case s.notifications <- line:
+	case <-s.closed:
+	}
+}
+
+// forward sends notifications tagged with instance to lines until the
+// session is closed
+func (s *session) forward(instance string, lines chan InstanceLine) {
+	for {
+		select {
+		case n := <-s.notifications:
+			select {
+			case lines <- InstanceLine{instance, n}:
+			case <-s.closed:
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Send writes a command and waits for its response until ctx is done. The
+// session is closed when ctx is done after the command was written, a late
+// response would be matched to the next command. Commands answered with
+// several responses return the first error or the last response.
+func (s *session) Send(ctx context.Context, command string) (*Response, error) {
+	waiters := make([]chan *Response, replies(command))
+	for i := range waiters {
+		waiters[i] = make(chan *Response, 1)
+	}
+	if err := s.write(ctx, command, waiters); err != nil {
+		return nil, err
+	}
+	var resp *Response
+	for _, waiter := range waiters {
+		select {
+		case r := <-waiter:
+			if resp == nil || resp.Err() == nil {
+				resp = r
+			}
+		case <-s.closed:
+			return nil, ErrConnectionClosed
+		case <-ctx.Done():
+			glog.Warningf("Closing management connection without a response: %v", ctx.Err())
+			_ = s.Close()
+			return nil, ctx.Err()
+		}
+	}
+	return resp, nil
+}
+
+// replies returns the number of responses written for command. The echo, log
+// and state commands are answered once per argument, e.g. "state on all" with
+// SUCCESS: and the history.
+func replies(command string) int {
+	args := strings.Fields(command)
+	if len(args) != 3 {
+		return 1
+	}
+	switch args[0] {
+	case "echo", "log", "state":
+		return 2
+	}
+	return 1
+}
+
+func (s *session) write(ctx context.Context, command string, waiters []chan *Response) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	select {
+	case <-s.closed:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	s.waitersLock.Lock()
+	s.waiters = append(s.waiters, waiters...)
+	s.waitersLock.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	_ = s.conn.SetWriteDeadline(deadline)
+	cmdStr := fmt.Sprintf("%s\n", strings.TrimSpace(command))
+	// Recorded first so the command precedes its response in the transcript
+	if s.tap != nil {
+		for _, line := range strings.Split(strings.TrimSpace(command), "\n") {
+			s.record(DirectionWrite, line)
+		}
+	}
+	if _, err := s.conn.Write([]byte(cmdStr)); err != nil {
+		// The stream is out of sync after a partial write
+		_ = s.Close()
+		return err
+	}
+	return nil
+}
+
+// setInstance sets the instance of the entries recorded by the tap
+func (s *session) setInstance(id string) {
+	s.instanceLock.Lock()
+	defer s.instanceLock.Unlock()
+	s.instance = id
+}
+
+func (s *session) record(direction, line string) {
+	s.instanceLock.Lock()
+	instance := s.instance
+	s.instanceLock.Unlock()
+	s.tap.Record(TranscriptEntry{
+		Time:      time.Now(),
+		Direction: direction,
+		Instance:  instance,
+		Line:      line,
+	})
+}
+
+func (s *session) shutdown() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+func (s *session) Close() error {
+	s.shutdown()
+	return s.conn.Close()
+}
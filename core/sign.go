@@ -0,0 +1,36 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SignEvent is a parsed >PK_SIGN: or legacy >RSA_SIGN: notification sent when
+// OpenVPN runs with --management-external-key.
+type SignEvent struct {
+	Data      []byte
+	Algorithm string
+	Legacy    bool
+}
+
+// CertificateEvent is a parsed >NEED-CERTIFICATE: notification sent when
+// OpenVPN runs with --management-external-cert
+type CertificateEvent struct {
+	Hint string
+}
+
+// ParseSign parses base64 data and the optional algorithm, the algorithm may
+// contain commas (e.g. RSA_PKCS1_PSS_PADDING,hashalg=SHA256,saltlen=digest)
+func ParseSign(data string, legacy bool) (*SignEvent, error) {
+	s := strings.SplitN(data, ",", 2)
+	d, err := base64.StdEncoding.DecodeString(s[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid sign data: %v", err)
+	}
+	se := &SignEvent{Data: d, Legacy: legacy}
+	if len(s) > 1 {
+		se.Algorithm = s[1]
+	}
+	return se, nil
+}
@@ -170,7 +170,7 @@ func (ca *CA) Sign(request *CSR) (*Cert, error) {
 	io.WriteString(pipe, "\n")
 
 	if err := cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("Exit code ", err, " - ", string(stderr.Bytes()))
+		return nil, fmt.Errorf("Exit code %v - %s", err, string(stderr.Bytes()))
 	}
 
 	cert := &Cert{
@@ -201,14 +201,14 @@ func (ca *CA) Revoke(cert *Cert) error {
 	pipe, _ := cmd.StdinPipe()
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("Exit code ", err, " - ", string(b.Bytes()))
+		return fmt.Errorf("Exit code %v - %s", err, string(b.Bytes()))
 	}
 
 	pipe.Write(cert.content)
 	io.WriteString(pipe, "\n")
 
 	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("Exit code ", err, " - ", string(b.Bytes()))
+		return fmt.Errorf("Exit code %v - %s", err, string(b.Bytes()))
 	}
 
 	// Uppdate the CRL (client revoke list)
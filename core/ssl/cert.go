@@ -1,6 +1,10 @@
 package openssl
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 )
@@ -58,7 +62,7 @@ func (o *Openssl) CreateCert(filename, keyfile, cn string, ca *CA, server bool)
 
 	cert, err := ca.Sign(request)
 	if err != nil {
-		return nil, fmt.Errorf("Sign csr failed: ", err)
+		return nil, fmt.Errorf("Sign csr failed: %v", err)
 	}
 
 	cert.path = filename
@@ -95,3 +99,31 @@ func (c *Cert) KeyString() string {
 	}
 	return ""
 }
+
+// PrivateKey parses the PEM encoded key of the certificate (PKCS#1, PKCS#8 or EC)
+func (c *Cert) PrivateKey() (crypto.Signer, error) {
+	if c == nil || len(c.contentKey) == 0 {
+		return nil, errors.New("no key loaded")
+	}
+	block, _ := pem.Decode(c.contentKey)
+	if block == nil {
+		return nil, errors.New("invalid PEM key")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type: %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", block.Type)
+}
@@ -0,0 +1,68 @@
+package openssl
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestPrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]*pem.Block{
+		"pkcs1": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"ec":    {Type: "EC PRIVATE KEY", Bytes: ecDer},
+		"pkcs8": {Type: "PRIVATE KEY", Bytes: pkcs8Der},
+	}
+	for name, block := range keys {
+		c := &Cert{contentKey: pem.EncodeToMemory(block)}
+		key, err := c.PrivateKey()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if name != "pkcs1" || k.N.Cmp(rsaKey.N) != 0 {
+				t.Errorf("%s: unexpected RSA key", name)
+			}
+		case *ecdsa.PrivateKey:
+			if name == "pkcs1" || k.D.Cmp(ecKey.D) != 0 {
+				t.Errorf("%s: unexpected EC key", name)
+			}
+		default:
+			t.Errorf("%s: unexpected key type %T", name, key)
+		}
+	}
+
+	invalid := []*Cert{
+		nil,
+		{},
+		{contentKey: []byte("not a key")},
+		{contentKey: pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: []byte{0}})},
+		{contentKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{0}})},
+	}
+	for i, c := range invalid {
+		if _, err := c.PrivateKey(); err == nil {
+			t.Errorf("Expected an error for key %d", i)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// OpenVPN connection states reported by >STATE: notifications
+const (
+	StateConnecting   = "CONNECTING"
+	StateWait         = "WAIT"
+	StateAuth         = "AUTH"
+	StateGetConfig    = "GET_CONFIG"
+	StateAssignIP     = "ASSIGN_IP"
+	StateAddRoutes    = "ADD_ROUTES"
+	StateConnected    = "CONNECTED"
+	StateReconnecting = "RECONNECTING"
+	StateExiting      = "EXITING"
+	StateResolve      = "RESOLVE"
+	StateTcpConnect   = "TCP_CONNECT"
+	StateAuthPending  = "AUTH_PENDING"
+)
+
+// StateEvent is a parsed >STATE: notification or a line of the state history
+type StateEvent struct {
+	Time          time.Time
+	State         string
+	Description   string
+	LocalIP       string
+	RemoteAddress string
+	RemotePort    int
+	LocalAddress  string
+	LocalPort     int
+	LocalIPv6     string
+}
+
+// ParseState parses the comma separated fields of a state line:
+// time,state,description,local ip,remote address,remote port,local address,local port,local ipv6
+func ParseState(data string) (*StateEvent, error) {
+	// Fields added after local ipv6 by later versions are ignored
+	s, _ := SplitFields(data, 10)
+	if s[1] == "" {
+		return nil, fmt.Errorf("invalid state: %q", data)
+	}
+	ts, err := strconv.ParseInt(s[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state time: %q", s[0])
+	}
+	port := func(i int) int {
+		n, err := strconv.Atoi(s[i])
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+	return &StateEvent{
+		Time:          time.Unix(ts, 0),
+		State:         s[1],
+		Description:   s[2],
+		LocalIP:       s[3],
+		RemoteAddress: s[4],
+		RemotePort:    port(5),
+		LocalAddress:  s[6],
+		LocalPort:     port(7),
+		LocalIPv6:     s[8],
+	}, nil
+}
@@ -0,0 +1,406 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusClientListTitle = "OpenVPN CLIENT LIST"
+	statusStatisticsTitle = "OpenVPN STATISTICS"
+)
+
+// statusTimeLayouts are the formats of the dates of status version 1, OpenVPN
+// 2.5 and later print ISO dates and older versions ANSIC dates
+var statusTimeLayouts = []string{"2006-01-02 15:04:05", time.ANSIC}
+
+// Status is the parsed output of the status command. Statistics is only set
+// when OpenVPN runs as a client, Clients and Routes when it runs as a server.
+type Status struct {
+	Title       string
+	Updated     time.Time
+	Clients     []StatusClient
+	Routes      []StatusRoute
+	GlobalStats map[string]string
+	Statistics  *ClientStatistics
+}
+
+// StatusClient is a row of the CLIENT LIST section joined with its rows of
+// the ROUTING TABLE section. The fields from Username to DataChannelCipher are
+// only reported by status version 2 and 3.
+type StatusClient struct {
+	CommonName         string
+	RealAddress        string
+	VirtualAddress     string
+	VirtualIPv6Address string
+	BytesReceived      int64
+	BytesSent          int64
+	ConnectedSince     time.Time
+	Username           string
+	ClientID           int
+	PeerID             int
+	DataChannelCipher  string
+	// LastRef is the last time a packet was routed to the client
+	LastRef time.Time
+	// Routes are the virtual addresses and networks routed to the client
+	Routes []string
+}
+
+// StatusRoute is a row of the ROUTING TABLE section
+type StatusRoute struct {
+	VirtualAddress string
+	CommonName     string
+	RealAddress    string
+	LastRef        time.Time
+}
+
+// ClientStatistics are the traffic counters reported by "status" when
+// OpenVPN runs as a client
+type ClientStatistics struct {
+	TunReadBytes        int64
+	TunWriteBytes       int64
+	LinkReadBytes       int64
+	LinkWriteBytes      int64
+	AuthReadBytes       int64
+	PreCompressBytes    int64
+	PostCompressBytes   int64
+	PreDecompressBytes  int64
+	PostDecompressBytes int64
+}
+
+// StatusError is returned for malformed status output, Line is the number of
+// the offending line or 0 when the output as a whole is invalid.
+type StatusError struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (e *StatusError) Error() string {
+	if e.Line == 0 {
+		return "invalid status: " + e.Reason
+	}
+	return fmt.Sprintf("invalid status line %d: %s: %q", e.Line, e.Reason, e.Text)
+}
+
+// Client returns the client connected with commonName from realAddress
+func (st *Status) Client(commonName, realAddress string) (*StatusClient, bool) {
+	for i := range st.Clients {
+		if st.Clients[i].CommonName == commonName && st.Clients[i].RealAddress == realAddress {
+			return &st.Clients[i], true
+		}
+	}
+	return nil, false
+}
+
+// merge joins the routing table to the client list by common name and real
+// address
+func (st *Status) merge() {
+	for _, r := range st.Routes {
+		c, ok := st.Client(r.CommonName, r.RealAddress)
+		if !ok {
+			continue
+		}
+		c.Routes = append(c.Routes, r.VirtualAddress)
+		if ip := net.ParseIP(r.VirtualAddress); ip != nil {
+			if ip.To4() != nil && c.VirtualAddress == "" {
+				c.VirtualAddress = r.VirtualAddress
+			} else if ip.To4() == nil && c.VirtualIPv6Address == "" {
+				c.VirtualIPv6Address = r.VirtualAddress
+			}
+		}
+		if r.LastRef.After(c.LastRef) {
+			c.LastRef = r.LastRef
+		}
+	}
+}
+
+func newStatus() *Status {
+	return &Status{
+		Clients:     make([]StatusClient, 0),
+		Routes:      make([]StatusRoute, 0),
+		GlobalStats: make(map[string]string),
+	}
+}
+
+// ParseStatus parses the output of any status version
+func ParseStatus(out string) (*Status, error) {
+	switch {
+	case strings.HasPrefix(out, "TITLE\t"):
+		return ParseStatus3(out)
+	case strings.HasPrefix(out, "TITLE,"):
+		return ParseStatus2(out)
+	}
+	return ParseStatus1(out)
+}
+
+// ParseStatus1 parses the output of "status 1", the client list of a server
+// or the statistics of a client.
+func ParseStatus1(out string) (*Status, error) {
+	st := newStatus()
+	var section string
+	var header []string
+	for n, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		switch line {
+		case statusClientListTitle, statusStatisticsTitle:
+			st.Title = line
+			section, header = line, nil
+			if line == statusStatisticsTitle {
+				st.Statistics = &ClientStatistics{}
+			}
+			continue
+		case "ROUTING TABLE", "GLOBAL STATS":
+			section, header = line, nil
+			continue
+		case ResponseEnd:
+			st.merge()
+			return st, nil
+		}
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if fields[0] == "Updated" && len(fields) == 2 {
+			updated, err := parseStatusTime(fields[1])
+			if err != nil {
+				return nil, &StatusError{n + 1, line, "invalid update time"}
+			}
+			st.Updated = updated
+			continue
+		}
+		var err error
+		switch section {
+		case statusStatisticsTitle:
+			err = st.Statistics.set(fields)
+		case "GLOBAL STATS":
+			if len(fields) != 2 {
+				err = fmt.Errorf("invalid global stat")
+			} else {
+				st.GlobalStats[fields[0]] = fields[1]
+			}
+		case statusClientListTitle, "ROUTING TABLE":
+			if header == nil {
+				header = fields
+				continue
+			}
+			err = st.addRow(section, header, fields)
+		default:
+			err = fmt.Errorf("unexpected line")
+		}
+		if err != nil {
+			return nil, &StatusError{n + 1, line, err.Error()}
+		}
+	}
+	if st.Title == "" {
+		return nil, &StatusError{Reason: "missing title"}
+	}
+	st.merge()
+	return st, nil
+}
+
+// set stores a counter of the statistics, unknown counters are ignored
+func (cs *ClientStatistics) set(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid counter")
+	}
+	value, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid counter")
+	}
+	switch fields[0] {
+	case "TUN/TAP read bytes":
+		cs.TunReadBytes = value
+	case "TUN/TAP write bytes":
+		cs.TunWriteBytes = value
+	case "TCP/UDP read bytes":
+		cs.LinkReadBytes = value
+	case "TCP/UDP write bytes":
+		cs.LinkWriteBytes = value
+	case "Auth read bytes":
+		cs.AuthReadBytes = value
+	case "pre-compress bytes":
+		cs.PreCompressBytes = value
+	case "post-compress bytes":
+		cs.PostCompressBytes = value
+	case "pre-decompress bytes":
+		cs.PreDecompressBytes = value
+	case "post-decompress bytes":
+		cs.PostDecompressBytes = value
+	}
+	return nil
+}
+
+// ParseStatus2 parses the comma delimited output of "status 2"
+func ParseStatus2(out string) (*Status, error) {
+	return parseStatusRows(out, ",")
+}
+
+// ParseStatus3 parses the tab delimited output of "status 3"
+func ParseStatus3(out string) (*Status, error) {
+	return parseStatusRows(out, "\t")
+}
+
+// IsStatusTitle reports if line starts a status version 2 or 3 block
+func IsStatusTitle(line string) bool {
+	return strings.HasPrefix(line, "TITLE,") || strings.HasPrefix(line, "TITLE\t")
+}
+
+// parseStatusRows parses status output where each row starts with its type
+// (TITLE, TIME, HEADER, CLIENT_LIST, ROUTING_TABLE, GLOBAL_STATS, END). The
+// columns of CLIENT_LIST and ROUTING_TABLE rows are named by HEADER rows.
+func parseStatusRows(out string, sep string) (*Status, error) {
+	st := newStatus()
+	headers := make(map[string][]string)
+	for n, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, sep)
+		var err error
+		switch fields[0] {
+		case "TITLE":
+			st.Title = strings.Join(fields[1:], sep)
+		case "TIME":
+			if len(fields) != 3 {
+				err = fmt.Errorf("invalid update time")
+			} else {
+				st.Updated, err = parseEpoch(fields[2])
+			}
+		case "HEADER":
+			if len(fields) < 3 {
+				err = fmt.Errorf("invalid header")
+			} else {
+				headers[fields[1]] = fields[2:]
+			}
+		case "CLIENT_LIST", "ROUTING_TABLE":
+			header, ok := headers[fields[0]]
+			if !ok {
+				err = fmt.Errorf("row without header")
+			} else {
+				err = st.addRow(fields[0], header, fields[1:])
+			}
+		case "GLOBAL_STATS":
+			if len(fields) != 3 {
+				err = fmt.Errorf("invalid global stat")
+			} else {
+				st.GlobalStats[fields[1]] = fields[2]
+			}
+		case ResponseEnd:
+			st.merge()
+			return st, nil
+		}
+		if err != nil {
+			return nil, &StatusError{n + 1, line, err.Error()}
+		}
+	}
+	if _, ok := headers["CLIENT_LIST"]; !ok {
+		return nil, &StatusError{Reason: "missing client list header"}
+	}
+	st.merge()
+	return st, nil
+}
+
+// addRow adds a client list or routing table row, the columns are named by
+// header in the same order as fields
+func (st *Status) addRow(section string, header []string, fields []string) error {
+	if len(fields) != len(header) {
+		return fmt.Errorf("expected %d columns, got %d", len(header), len(fields))
+	}
+	row := statusRow{values: make(map[string]string, len(header))}
+	for i, col := range header {
+		row.values[col] = fields[i]
+	}
+	if section == statusClientListTitle || section == "CLIENT_LIST" {
+		c := StatusClient{
+			CommonName:         row.values["Common Name"],
+			RealAddress:        row.values["Real Address"],
+			VirtualAddress:     row.values["Virtual Address"],
+			VirtualIPv6Address: row.values["Virtual IPv6 Address"],
+			BytesReceived:      row.int64("Bytes Received"),
+			BytesSent:          row.int64("Bytes Sent"),
+			ConnectedSince:     row.time("Connected Since"),
+			Username:           row.values["Username"],
+			ClientID:           int(row.int64("Client ID")),
+			PeerID:             int(row.int64("Peer ID")),
+			DataChannelCipher:  row.values["Data Channel Cipher"],
+			Routes:             make([]string, 0),
+		}
+		if row.err == nil {
+			st.Clients = append(st.Clients, c)
+		}
+	} else {
+		r := StatusRoute{
+			VirtualAddress: row.values["Virtual Address"],
+			CommonName:     row.values["Common Name"],
+			RealAddress:    row.values["Real Address"],
+			LastRef:        row.time("Last Ref"),
+		}
+		if row.err == nil {
+			st.Routes = append(st.Routes, r)
+		}
+	}
+	return row.err
+}
+
+// statusRow converts the columns of a row, the first conversion error is kept
+type statusRow struct {
+	values map[string]string
+	err    error
+}
+
+// int64 returns the value of col, 0 if the column is missing
+func (r *statusRow) int64(col string) int64 {
+	v := r.values[col]
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("invalid %s", col)
+	}
+	return n
+}
+
+// time returns the value of col, preferring its "(time_t)" column
+func (r *statusRow) time(col string) time.Time {
+	var t time.Time
+	var err error
+	if v, ok := r.values[col+" (time_t)"]; ok {
+		t, err = parseEpoch(v)
+	} else if v := r.values[col]; v != "" {
+		t, err = parseStatusTime(v)
+	}
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("invalid %s", col)
+	}
+	return t
+}
+
+// parseStatusTime parses a local date in one of statusTimeLayouts
+func parseStatusTime(v string) (t time.Time, err error) {
+	for _, layout := range statusTimeLayouts {
+		if t, err = time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+func parseEpoch(d string) (time.Time, error) {
+	n, err := strconv.ParseInt(d, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(n, 0), nil
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/mgmttest"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTcpConnectorAddress(t *testing.T) {
+	srv := mgmttest.New(t)
+	defer srv.Close()
+	srv.Pid = 4242
+	host, port, err := net.SplitHostPort(srv.Listen("tcp", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+
+	c := NewTcpConnector(host, n, "", ClientMode)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err = c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.SendCommand(ctx, "pid"); err != nil || resp != "SUCCESS: pid=4242" {
+		t.Errorf("Unexpected response %q: %v", resp, err)
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitNotification splits a real-time line ">SOURCE:payload" at its first
+// colon, the payload is returned as is and may contain colons (IPv6
+// addresses, ENV values). ok is false if line is not a notification.
+func SplitNotification(line string) (source string, payload string, ok bool) {
+	if !strings.HasPrefix(line, ">") {
+		return "", "", false
+	}
+	source, payload = splitFirst(line[1:], ":")
+	return source, payload, true
+}
+
+// SplitFields splits data into n comma separated fields with the semantics
+// of strings.SplitN, the last field keeps its commas (e.g. a log message).
+// Missing fields are empty and ok is false, so the fields can always be
+// indexed.
+func SplitFields(data string, n int) (fields []string, ok bool) {
+	if n <= 0 {
+		return []string{}, data == ""
+	}
+	fields = strings.SplitN(data, ",", n)
+	ok = len(fields) == n
+	for len(fields) < n {
+		fields = append(fields, "")
+	}
+	return fields, ok
+}
+
+// SplitKeyValue splits "key=value" at the first '=', the value may contain
+// '=' and ':' (e.g. "tls_id_0=CN=client,O=example"). ok is false without '='.
+func SplitKeyValue(data string) (key string, value string, ok bool) {
+	i := strings.Index(data, "=")
+	if i < 0 {
+		return data, "", false
+	}
+	return data[:i], data[i+1:], true
+}
+
+func splitFirst(s string, sep string) (string, string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+	return s, ""
+}
+
+// SplitCommand splits a command line into its name and arguments like the
+// management interface does: arguments are separated by white space, double
+// quotes group an argument with spaces and a backslash escapes the next
+// character (see Quote). Unterminated quotes and escapes are errors.
+func SplitCommand(line string) ([]string, error) {
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			inArg, escaped = true, true
+		case r == '"':
+			inArg, quoted = true, !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\r' || r == '\n'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("unterminated escape: %q", line)
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote: %q", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	cases := map[string][]string{
+		`client-kill 5`:                      {"client-kill", "5"},
+		`  state   on `:                      {"state", "on"},
+		`password "Private Key" "p a\"ss\\"`: {"password", "Private Key", `p a"ss\`},
+		`username Auth us\ er`:               {"username", "Auth", "us er"},
+		`client-deny 1 2 "" "bad"`:           {"client-deny", "1", "2", "", "bad"},
+		`needstr "name" "a\tb"`:              {"needstr", "name", "atb"},
+		"":                                   {},
+	}
+	for line, expected := range cases {
+		args, err := SplitCommand(line)
+		if err != nil || !reflect.DeepEqual(args, expected) {
+			t.Errorf("%q: expected %q, got %q, %v", line, expected, args, err)
+		}
+	}
+	for _, line := range []string{`password "Auth`, `username Auth user\`} {
+		if _, err := SplitCommand(line); err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+	// Quote produces arguments SplitCommand reads back
+	for _, arg := range []string{`a "quoted" \ arg`, `\`, `"`, ""} {
+		args, err := SplitCommand("echo " + Quote(arg))
+		if err != nil || len(args) != 2 || args[1] != arg {
+			t.Errorf("%q: round trip failed: %q, %v", arg, args, err)
+		}
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	fields, ok := SplitFields("1392336000,W,message, with, commas", 3)
+	if !ok || fields[2] != "message, with, commas" {
+		t.Errorf("Unexpected fields: %q", fields)
+	}
+	fields, ok = SplitFields("1", 3)
+	if ok || len(fields) != 3 || fields[0] != "1" || fields[2] != "" {
+		t.Errorf("Unexpected fields: %q", fields)
+	}
+	k, v, ok := SplitKeyValue("tls_id_0=CN=client,O=example:org")
+	if !ok || k != "tls_id_0" || v != "CN=client,O=example:org" {
+		t.Errorf("Unexpected key value: %q=%q", k, v)
+	}
+	if _, _, ok = SplitKeyValue("novalue"); ok {
+		t.Error("Expected missing value")
+	}
+	source, payload, ok := SplitNotification(">LOG:1392336000,I,fe80::1 connected: ok")
+	if !ok || source != "LOG" || payload != "1392336000,I,fe80::1 connected: ok" {
+		t.Errorf("Unexpected notification: %q %q", source, payload)
+	}
+}
+
+func TestParseEscapedValues(t *testing.T) {
+	parser := NewCommandParser()
+	lines := []string{
+		">CLIENT:CONNECT,0,1",
+		">CLIENT:ENV,username=o'brien=admin:root",
+		">CLIENT:ENV,IV_CIPHERS=AES-256-GCM:AES-128-GCM",
+		">CLIENT:ENV,untrusted_ip6=2001:db8::1",
+		">CLIENT:ENV,empty=",
+		">CLIENT:ENV,END",
+	}
+	var evt *EventData
+	for _, l := range lines {
+		evt = parser.ParseEvent(l)
+	}
+	if evt == nil || !evt.Completed {
+		t.Fatalf("Incomplete event: %+v", evt)
+	}
+	expected := map[string]string{
+		"client_id":     "0",
+		"key_id":        "1",
+		"username":      "o'brien=admin:root",
+		"IV_CIPHERS":    "AES-256-GCM:AES-128-GCM",
+		"untrusted_ip6": "2001:db8::1",
+		"empty":         "",
+	}
+	if !reflect.DeepEqual(evt.Data, expected) {
+		t.Errorf("Unexpected data: %v", evt.Data)
+	}
+
+	evt = parser.ParseEvent(">CLIENT:ADDRESS,3,2001:db8::2,1")
+	if evt.Get("client_address") != "2001:db8::2" || evt.Get("primary_address") != "1" {
+		t.Errorf("Unexpected address: %v", evt.Data)
+	}
+	evt = parser.ParseEvent(">LOG:1392336000,W,peer [AF_INET6]2001:db8::3:1194, cipher AES-256-GCM")
+	if l, ok := evt.Log(); !ok || l.Message != "peer [AF_INET6]2001:db8::3:1194, cipher AES-256-GCM" {
+		t.Errorf("Unexpected log: %+v", evt)
+	}
+	evt = parser.ParseEvent(">HOLD:Waiting for hold release:")
+	if evt.EventData != "Waiting for hold release:" {
+		t.Errorf("Unexpected hold: %+v", evt)
+	}
+}
+
+func TestParseStateProxyFields(t *testing.T) {
+	st, err := ParseState("1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,,fd00::1,extra")
+	if err != nil || st.LocalIPv6 != "fd00::1" || st.RemotePort != 1194 {
+		t.Errorf("Unexpected state %+v: %v", st, err)
+	}
+	st, err = ParseState("1392336000,WAIT")
+	if err != nil || st.State != StateWait || st.Description != "" {
+		t.Errorf("Unexpected short state %+v: %v", st, err)
+	}
+	if _, err = ParseState("1392336000"); err == nil {
+		t.Error("Expected an error for a state without name")
+	}
+	pe, err := ParseProxy("1,TCP,vpn.example.com")
+	if err != nil || pe.Host != "vpn.example.com" || pe.Port != 0 {
+		t.Errorf("Unexpected proxy %+v: %v", pe, err)
+	}
+	pe, err = ParseProxy("2,udp,vpn.example.com,1194,extra")
+	if err != nil || pe.Index != 2 || pe.Port != 1194 {
+		t.Errorf("Unexpected proxy %+v: %v", pe, err)
+	}
+	if _, err = ParseProxy("1,TCP"); err == nil {
+		t.Error("Expected an error for a proxy without host")
+	}
+}
+
+// TestParseMalformed feeds every prefix of valid lines, none may panic
+func TestParseMalformed(t *testing.T) {
+	lines := []string{
+		">CLIENT:CONNECT,0,1",
+		">CLIENT:REAUTH,0,1",
+		">CLIENT:ENV,username=user",
+		">CLIENT:ADDRESS,3,10.8.0.2,1",
+		">CLIENT:CR_RESPONSE,0,1,c2VjcmV0",
+		">CLIENT:DISCONNECT,0",
+		">BYTECOUNT:10,20",
+		">BYTECOUNT_CLI:1,10,20",
+		">STATE:1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,",
+		">LOG:1392336000,I,message",
+		">ECHO:1392336000,param",
+		">PASSWORD:Need 'Auth' username/password SC:1,Enter PIN",
+		">PASSWORD:Verification Failed: 'Auth' ['CRV1:R,E:Om01u7Fh4LrGBS7uh0SWmzwabUiGiW6l:Y3Ix:Please enter token PIN']",
+		">NEED-OK:Need 'token-insertion-request' confirmation MSG:Please insert your token",
+		">PK_SIGN:ZGF0YQ==,RSA_PKCS1_PADDING",
+		">REMOTE:vpn.example.com,1194,udp",
+		">PROXY:1,udp,vpn.example.com,1194",
+		">HOLD:Waiting for hold release:10",
+		">INFO:OpenVPN Management Interface Version 5",
+		">MANAGEMENT:CONNECTED",
+		"TITLE,OpenVPN 2.6.3",
+		"OpenVPN CLIENT LIST",
+		"END",
+	}
+	for _, line := range lines {
+		for i := 0; i <= len(line); i++ {
+			parser := NewCommandParser()
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%q: panic: %v", line[:i], r)
+					}
+				}()
+				parser.ParseEvent(line[:i])
+				parser.ParseEvent(">CLIENT:ENV,END")
+				parser.ParseEvent("END")
+			}()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DirectionRead  = "read"  // line received from OpenVPN
+	DirectionWrite = "write" // line sent to OpenVPN
+)
+
+const (
+	redacted         = "***"
+	crResponsePrefix = ">CLIENT:CR_RESPONSE,"
+)
+
+// TranscriptEntry is a line of a management session, Instance is set in
+// server mode once the instance is identified (see InstanceConnector).
+type TranscriptEntry struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Instance  string    `json:"instance,omitempty"`
+	Line      string    `json:"line"`
+}
+
+// Tap receives every line read from and written to OpenVPN, except the
+// management password. Record is called from the goroutines of the sessions.
+type Tap interface {
+	Record(entry TranscriptEntry)
+}
+
+// Tappable is implemented by connectors that can record their sessions, the
+// tap must be set before Connect.
+type Tappable interface {
+	SetTap(tap Tap)
+}
+
+// TranscriptWriter is a Tap writing JSON lines. Passwords sent with the
+// password command and received in CLIENT:ENV lines, and the responses of
+// CLIENT:CR_RESPONSE lines are replaced by *** unless Redact is false.
+type TranscriptWriter struct {
+	Redact bool
+	lock   sync.Mutex
+	w      io.Writer
+	enc    *json.Encoder
+	err    error
+}
+
+func NewTranscriptWriter(w io.Writer) *TranscriptWriter {
+	return &TranscriptWriter{
+		Redact: true,
+		w:      w,
+		enc:    json.NewEncoder(w),
+	}
+}
+
+// CreateTranscript returns a writer to the file path, it is truncated if it
+// exists
+func CreateTranscript(path string) (*TranscriptWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTranscriptWriter(f), nil
+}
+
+func (tw *TranscriptWriter) Record(entry TranscriptEntry) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.err != nil {
+		return
+	}
+	if tw.Redact {
+		entry.Line = redact(entry.Line)
+	}
+	tw.err = tw.enc.Encode(entry)
+}
+
+// Err returns the first write error, entries are no longer written after it
+func (tw *TranscriptWriter) Err() error {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	return tw.err
+}
+
+// Close closes the underlying writer if it is an io.Closer
+func (tw *TranscriptWriter) Close() error {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if c, ok := tw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func redact(line string) string {
+	if strings.HasPrefix(line, ">CLIENT:ENV,password=") {
+		return ">CLIENT:ENV,password=" + redacted
+	}
+	// The response to a challenge is an OTP or another secret
+	if strings.HasPrefix(line, crResponsePrefix) {
+		fields, ok := SplitFields(strings.TrimPrefix(line, crResponsePrefix), 3)
+		if !ok {
+			return crResponsePrefix + redacted
+		}
+		return fmt.Sprintf("%s%s,%s,%s", crResponsePrefix, fields[0], fields[1], redacted)
+	}
+	if !strings.HasPrefix(line, "password") {
+		return line
+	}
+	args, err := SplitCommand(line)
+	if err == nil && args[0] != "password" {
+		return line
+	}
+	if err != nil || len(args) < 3 {
+		return "password " + redacted
+	}
+	return fmt.Sprintf("password %s %s", Quote(args[1]), redacted)
+}
+
+// ReadTranscript reads the JSON lines written by TranscriptWriter
+func ReadTranscript(r io.Reader) ([]TranscriptEntry, error) {
+	entries := make([]TranscriptEntry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry TranscriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid transcript line %d: %v", n, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReadTranscriptFile reads the transcript stored in path
+func ReadTranscriptFile(path string) ([]TranscriptEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTranscript(f)
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"github.com/mungaij83/go-openvpn/mgmttest"
+	"testing"
+	"time"
+)
+
+func TestTranscriptTap(t *testing.T) {
+	srv := mgmttest.New(t)
+	defer srv.Close()
+	srv.Password = "management"
+	srv.Handle("version", mgmttest.Reply(">CLIENT:ENV,password=secret", "OpenVPN Version: OpenVPN 2.6.3", "END"))
+	socket := srv.Listen("unix", "")
+
+	var buffer bytes.Buffer
+	tw := NewTranscriptWriter(&buffer)
+	c := NewSocketConnector(socket, "management", ClientMode)
+	c.(Tappable).SetTap(tw)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendCommand(ctx, `password "Auth" "s3cr\"et"`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SendCommand(ctx, "version"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+
+	entries, err := ReadTranscript(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The banner is read concurrently with the first command
+	expected := []TranscriptEntry{
+		{Direction: DirectionWrite, Line: `password "Auth" ***`},
+		{Direction: DirectionRead, Line: "SUCCESS: password command succeeded"},
+		{Direction: DirectionWrite, Line: "version"},
+		{Direction: DirectionRead, Line: ">CLIENT:ENV,password=***"},
+		{Direction: DirectionRead, Line: "OpenVPN Version: OpenVPN 2.6.3"},
+		{Direction: DirectionRead, Line: ResponseEnd},
+	}
+	if len(entries) != len(expected)+1 {
+		t.Fatalf("Unexpected transcript: %+v", entries)
+	}
+	if entries[0].Line == mgmttest.Banner {
+		entries[0], entries[1] = entries[1], entries[0]
+	}
+	if entries[1].Line != mgmttest.Banner {
+		t.Errorf("Expected the banner, got %+v", entries[1])
+	}
+	entries = append(entries[:1], entries[2:]...)
+	for i, e := range expected {
+		if entries[i].Direction != e.Direction || entries[i].Line != e.Line || entries[i].Time.IsZero() {
+			t.Errorf("Expected %+v, got %+v", e, entries[i])
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	lines := map[string]string{
+		`password "Auth" "s3cr\"et"`:           `password "Auth" ***`,
+		`password Auth`:                        `password ***`,
+		`password "Auth`:                       `password ***`,
+		">CLIENT:ENV,password=secret":          ">CLIENT:ENV,password=***",
+		">CLIENT:CR_RESPONSE,8,2,MTIzNDU2":     ">CLIENT:CR_RESPONSE,8,2,***",
+		">CLIENT:CR_RESPONSE,8":                ">CLIENT:CR_RESPONSE,***",
+		">CLIENT:ENV,common_name=client1":      ">CLIENT:ENV,common_name=client1",
+		"passwords are not redacted otherwise": "passwords are not redacted otherwise",
+	}
+	for line, expected := range lines {
+		if r := redact(line); r != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, line, r)
+		}
+	}
+}
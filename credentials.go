@@ -0,0 +1,149 @@
+package openvpn
+
+import (
+	"errors"
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+)
+
+// CredentialProvider answers the credential prompts sent by OpenVPN in client mode
+type CredentialProvider interface {
+	// Credentials returns the username and password for a PASSWORD request,
+	// the username is ignored when only a password is needed (e.g. 'Private Key')
+	Credentials(req *core.PasswordEvent) (username string, password string, err error)
+	// ChallengeResponse returns the response to a static or dynamic challenge
+	ChallengeResponse(req *core.PasswordEvent, challenge string) (string, error)
+	// NeedOK confirms or cancels a NEED-OK request
+	NeedOK(req *core.NeedEvent) (bool, error)
+	// NeedStr returns the string requested by a NEED-STR request
+	NeedStr(req *core.NeedEvent) (string, error)
+}
+
+// StaticCredentials answers username/password requests with fixed values
+type StaticCredentials struct {
+	Username string
+	Password string
+}
+
+func (sc StaticCredentials) Credentials(req *core.PasswordEvent) (string, string, error) {
+	return sc.Username, sc.Password, nil
+}
+
+func (sc StaticCredentials) ChallengeResponse(req *core.PasswordEvent, challenge string) (string, error) {
+	return "", errors.New("challenge response not supported")
+}
+
+func (sc StaticCredentials) NeedOK(req *core.NeedEvent) (bool, error) {
+	return false, errors.New("need-ok not supported")
+}
+
+func (sc StaticCredentials) NeedStr(req *core.NeedEvent) (string, error) {
+	return "", errors.New("need-str not supported")
+}
+
+// SetCredentialProvider sets the provider used to answer PASSWORD, NEED-OK
+// and NEED-STR requests, it should be set before the management is started.
+func (vm *OpenVpnManagement) SetCredentialProvider(provider CredentialProvider) {
+	vm.credentialLock.Lock()
+	defer vm.credentialLock.Unlock()
+	vm.credentials = provider
+}
+
+func (vm *OpenVpnManagement) credentialProvider() CredentialProvider {
+	vm.credentialLock.Lock()
+	defer vm.credentialLock.Unlock()
+	return vm.credentials
+}
+
+// handleCredentials answers credential prompts, requests without a provider
+// are left for the consumer of Events.
+func (vm *OpenVpnManagement) handleCredentials(evt *core.EventData) {
+	provider := vm.credentialProvider()
+	if provider == nil {
+		return
+	}
+	if pe, ok := evt.Password(); ok {
+		vm.spawn(func() {
+			if err := vm.answerPassword(vm.Instance(evt.Instance), provider, pe); err != nil {
+				glog.Errorf("Failed to answer password request '%s': %v", pe.Type, err)
+			}
+		})
+	} else if ne, ok := evt.Need(); ok {
+		event := evt.Event
+		vm.spawn(func() {
+			if err := vm.Instance(evt.Instance).answerNeed(provider, event, ne); err != nil {
+				glog.Errorf("Failed to answer %s request '%s': %v", event, ne.Name, err)
+			}
+		})
+	}
+}
+
+func (vm *OpenVpnManagement) answerPassword(c *Commands, provider CredentialProvider, pe *core.PasswordEvent) error {
+	if pe.AuthToken != "" {
+		glog.V(2).Infof("Received an auth token")
+		return nil
+	}
+	if pe.VerificationFailed {
+		if pe.DynamicChallenge != nil {
+			// OpenVPN restarts and asks again, the challenge is answered then
+			vm.credentialLock.Lock()
+			vm.challenge = pe.DynamicChallenge
+			vm.credentialLock.Unlock()
+			return nil
+		}
+		glog.Warningf("Password verification failed: %s", pe.Type)
+		return nil
+	}
+
+	// The challenge answers the next 'Auth' prompt, e.g. not a 'Private Key' one
+	var challenge *core.DynamicChallenge
+	if pe.Type == "Auth" {
+		vm.credentialLock.Lock()
+		challenge = vm.challenge
+		vm.challenge = nil
+		vm.credentialLock.Unlock()
+	}
+
+	var username, password string
+	var err error
+	if challenge != nil && pe.NeedUsername {
+		response, err := provider.ChallengeResponse(pe, challenge.Text)
+		if err != nil {
+			return err
+		}
+		username, password = challenge.Username, challenge.Password(response)
+	} else {
+		username, password, err = provider.Credentials(pe)
+		if err != nil {
+			return err
+		}
+		if pe.StaticChallenge != nil {
+			response, err := provider.ChallengeResponse(pe, pe.StaticChallenge.Text)
+			if err != nil {
+				return err
+			}
+			password = pe.StaticChallenge.Password(password, response)
+		}
+	}
+	if pe.NeedUsername {
+		if err = c.Username(pe.Type, username); err != nil {
+			return err
+		}
+	}
+	return c.Password(pe.Type, password)
+}
+
+func (c *Commands) answerNeed(provider CredentialProvider, event string, ne *core.NeedEvent) error {
+	if event == "NEED-OK" {
+		ok, err := provider.NeedOK(ne)
+		if err != nil {
+			return err
+		}
+		return c.NeedOK(ne.Name, ok)
+	}
+	value, err := provider.NeedStr(ne)
+	if err != nil {
+		return err
+	}
+	return c.NeedStr(ne.Name, value)
+}
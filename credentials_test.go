@@ -0,0 +1,125 @@
+package openvpn
+
+import (
+	"github.com/mungaij83/go-openvpn/core"
+	"testing"
+	"time"
+)
+
+type challengeCredentials struct {
+	StaticCredentials
+	response string
+}
+
+func (cc challengeCredentials) ChallengeResponse(req *core.PasswordEvent, challenge string) (string, error) {
+	return cc.response, nil
+}
+
+func (cc challengeCredentials) NeedOK(req *core.NeedEvent) (bool, error) {
+	return true, nil
+}
+
+// feed sends notifications through the dispatcher
+func feed(vm *OpenVpnManagement, lines ...string) {
+	go func() {
+		for _, l := range lines {
+			vm.events <- l
+		}
+	}()
+}
+
+func TestPasswordRequest(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: 'Auth' password entered, but not yet verified")
+	vm.SetCredentialProvider(StaticCredentials{Username: "user", Password: `p"ss`})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PASSWORD:Need 'Auth' username/password")
+	commands := f.waitCommands(t, 2)
+	if commands[0] != `username "Auth" "user"` || commands[1] != `password "Auth" "p\"ss"` {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func TestStaticChallenge(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	vm.SetCredentialProvider(challengeCredentials{StaticCredentials{"user", "pass"}, "123456"})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PASSWORD:Need 'Auth' username/password SC:1,Please enter token PIN")
+	commands := f.waitCommands(t, 2)
+	if commands[1] != `password "Auth" "SCRV1:cGFzcw==:MTIzNDU2"` {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func TestStaticChallengeConcat(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	vm.SetCredentialProvider(challengeCredentials{StaticCredentials{"user", "pass"}, "123456"})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PASSWORD:Need 'Auth' username/password SC:3,Please enter token PIN")
+	commands := f.waitCommands(t, 2)
+	if commands[1] != `password "Auth" "pass123456"` {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func TestDynamicChallenge(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	vm.SetCredentialProvider(challengeCredentials{StaticCredentials{"user", "pass"}, "654321"})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PASSWORD:Verification Failed: 'Auth' ['CRV1:R,E:Om01u7Fh4LrGBS7uh0SWmzwabUiGiW6l:Y3Ix:Please enter token PIN']")
+	waitChallenge(vm)
+	feed(vm, ">PASSWORD:Need 'Auth' username/password")
+	commands := f.waitCommands(t, 2)
+	if commands[0] != `username "Auth" "cr1"` || commands[1] != `password "Auth" "CRV1::Om01u7Fh4LrGBS7uh0SWmzwabUiGiW6l::654321"` {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func TestDynamicChallengeOtherPrompt(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	vm.SetCredentialProvider(challengeCredentials{StaticCredentials{"user", "pass"}, "654321"})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PASSWORD:Verification Failed: 'Auth' ['CRV1:R,E:Om01u7Fh4LrGBS7uh0SWmzwabUiGiW6l:Y3Ix:Please enter token PIN']")
+	waitChallenge(vm)
+	// The token is not answered
+	feed(vm, ">PASSWORD:Auth-Token:SESS_ID_AT_0123456789abcdef",
+		">PASSWORD:Need 'Private Key' password")
+	commands := f.waitCommands(t, 1)
+	if commands[0] != `password "Private Key" "pass"` {
+		t.Errorf("Unexpected private key commands: %v", commands)
+	}
+	feed(vm, ">PASSWORD:Need 'Auth' username/password")
+	commands = f.waitCommands(t, 3)
+	if len(commands) != 3 || commands[2] != `password "Auth" "CRV1::Om01u7Fh4LrGBS7uh0SWmzwabUiGiW6l::654321"` {
+		t.Errorf("Expected the challenge to be answered, got %v", commands)
+	}
+}
+
+// waitChallenge waits until the dynamic challenge of a failed verification is stored
+func waitChallenge(vm *OpenVpnManagement) {
+	for i := 0; i < 100; i++ {
+		vm.credentialLock.Lock()
+		c := vm.challenge
+		vm.credentialLock.Unlock()
+		if c != nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNeedOK(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	vm.SetCredentialProvider(challengeCredentials{})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">NEED-OK:Need 'token-insertion-request' confirmation MSG:Please insert your cryptographic token")
+	commands := f.waitCommands(t, 1)
+	if commands[0] != `needok "token-insertion-request" ok` {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
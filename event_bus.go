@@ -0,0 +1,187 @@
+package openvpn
+
+import (
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultSubscriptionBuffer = 100
+
+// OverflowPolicy decides what happens to an event published to a full
+// subscription, the zero value is OverflowDropOldest
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest buffered event
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest discards the published event
+	OverflowDropNewest
+	// OverflowBlock waits until the subscriber reads, it delays every other
+	// subscriber and the reading of the management interface
+	OverflowBlock
+)
+
+// EventFilter selects the events delivered to a subscription, nil accepts all
+type EventFilter func(evt core.EventData) bool
+
+// EventKinds accepts events by name, e.g. "STATE", "LOG", "BYTECOUNT",
+// "CLIENT_CONNECT". The name of a client event without its type, "CLIENT",
+// accepts all client events.
+func EventKinds(names ...string) EventFilter {
+	kinds := make(map[string]bool, len(names))
+	for _, n := range names {
+		kinds[n] = true
+	}
+	return func(evt core.EventData) bool {
+		return kinds[evt.EventName()] || kinds[evt.Event]
+	}
+}
+
+// SubscriptionOptions configures a subscription, Buffer defaults to
+// DefaultSubscriptionBuffer and Policy to OverflowDropOldest
+type SubscriptionOptions struct {
+	Buffer int
+	Policy OverflowPolicy
+}
+
+// Subscription receives the events accepted by its filter
+type Subscription struct {
+	bus     *EventBus
+	filter  EventFilter
+	policy  OverflowPolicy
+	events  chan core.EventData
+	lock    sync.Mutex
+	dropped uint64
+	done    chan bool
+	once    sync.Once
+}
+
+// Events returns the channel of the subscription, it is closed by Close
+func (s *Subscription) Events() <-chan core.EventData {
+	return s.events
+}
+
+// Dropped returns the number of events lost because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription and closes its channel
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.bus.remove(s)
+	})
+}
+
+func (s *Subscription) drop(evt core.EventData) {
+	n := atomic.AddUint64(&s.dropped, 1)
+	glog.V(2).Infof("Subscription full, dropped event %s (%d dropped)", evt.EventName(), n)
+}
+
+func (s *Subscription) publish(evt core.EventData) {
+	if s.filter != nil && !s.filter(evt) {
+		return
+	}
+	switch s.policy {
+	case OverflowBlock:
+		select {
+		case s.events <- evt:
+		case <-s.done:
+		}
+	case OverflowDropNewest:
+		select {
+		case s.events <- evt:
+		default:
+			s.drop(evt)
+		}
+	case OverflowDropOldest:
+		// The lock keeps concurrent publishers from dropping twice
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for {
+			select {
+			case s.events <- evt:
+				return
+			default:
+			}
+			select {
+			case old := <-s.events:
+				s.drop(old)
+			default:
+			}
+		}
+	}
+}
+
+// EventBus delivers the events of a management interface to any number of
+// subscribers, each with its own buffer.
+type EventBus struct {
+	lock          sync.RWMutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscriptions: make(map[*Subscription]bool)}
+}
+
+// Subscribe returns a subscription to the events accepted by filter
+func (b *EventBus) Subscribe(filter EventFilter, options SubscriptionOptions) *Subscription {
+	size := options.Buffer
+	if size <= 0 {
+		size = DefaultSubscriptionBuffer
+	}
+	s := &Subscription{
+		bus:    b,
+		filter: filter,
+		policy: options.Policy,
+		events: make(chan core.EventData, size),
+		done:   make(chan bool),
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		s.once.Do(func() {
+			close(s.done)
+			close(s.events)
+		})
+		return s
+	}
+	b.subscriptions[s] = true
+	return s
+}
+
+// Publish sends evt to every subscription accepting it
+func (b *EventBus) Publish(evt core.EventData) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for s := range b.subscriptions {
+		s.publish(evt)
+	}
+}
+
+// Close closes every subscription, later subscriptions are closed at once
+func (b *EventBus) Close() {
+	b.lock.Lock()
+	b.closed = true
+	subscriptions := make([]*Subscription, 0, len(b.subscriptions))
+	for s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	b.lock.Unlock()
+	for _, s := range subscriptions {
+		s.Close()
+	}
+}
+
+// remove is called once the subscription is done, publishers holding the
+// read lock return before its channel is closed.
+func (b *EventBus) remove(s *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscriptions, s)
+	close(s.events)
+}
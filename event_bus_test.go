@@ -0,0 +1,105 @@
+package openvpn
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"testing"
+	"time"
+)
+
+func TestEventBusFilter(t *testing.T) {
+	vm := newManagement(&fakeConnector{}, core.ClientMode)
+	go vm.dispatch()
+	defer vm.Shutdown(context.Background())
+	states := vm.Subscribe(EventKinds("STATE"), SubscriptionOptions{})
+	clients := vm.Subscribe(EventKinds("CLIENT"), SubscriptionOptions{})
+	all := vm.Subscribe(nil, SubscriptionOptions{})
+
+	vm.events <- ">STATE:1392336000,WAIT,,,,,,"
+	vm.events <- ">CLIENT:DISCONNECT,5"
+	vm.events <- ">CLIENT:ENV,END"
+	vm.events <- ">BYTECOUNT:3,5"
+
+	for _, want := range []string{"STATE", "CLIENT_DISCONNECT", "BYTECOUNT"} {
+		if evt := receive(t, all); evt.EventName() != want {
+			t.Errorf("Expected %s, got %s", want, evt.EventName())
+		}
+	}
+	if evt := receive(t, states); evt.EventName() != "STATE" {
+		t.Errorf("Unexpected state event: %+v", evt)
+	}
+	if evt := receive(t, clients); evt.EventName() != "CLIENT_DISCONNECT" {
+		t.Errorf("Unexpected client event: %+v", evt)
+	}
+	if len(states.Events()) != 0 || len(clients.Events()) != 0 {
+		t.Error("Unexpected events delivered")
+	}
+}
+
+func TestManagementFire(t *testing.T) {
+	m := NewManagement(nil, &fakeConnector{})
+	defer m.Shutdown()
+	m.Fire("UNSEEN")
+	sub := m.Subscribe(EventKinds("HOLD"), SubscriptionOptions{})
+	m.Fire("HOLD", "Waiting for hold release", "10")
+	evt := receive(t, sub)
+	if args, _ := evt.Payload.([]string); evt.EventData != "Waiting for hold release,10" || len(args) != 2 {
+		t.Errorf("Unexpected event: %+v", evt)
+	}
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	bus := NewEventBus()
+	// The default policy drops the oldest event
+	oldest := bus.Subscribe(nil, SubscriptionOptions{Buffer: 2})
+	newest := bus.Subscribe(nil, SubscriptionOptions{Buffer: 2, Policy: OverflowDropNewest})
+	for _, name := range []string{"A", "B", "C"} {
+		bus.Publish(core.EventData{Event: name})
+	}
+	if oldest.Dropped() != 1 || newest.Dropped() != 1 {
+		t.Errorf("Invalid drop counters: %d, %d", oldest.Dropped(), newest.Dropped())
+	}
+	if evt := receive(t, oldest); evt.Event != "B" {
+		t.Errorf("Expected B, got %s", evt.Event)
+	}
+	if evt := receive(t, newest); evt.Event != "A" {
+		t.Errorf("Expected A, got %s", evt.Event)
+	}
+
+	blocking := bus.Subscribe(nil, SubscriptionOptions{Buffer: 1, Policy: OverflowBlock})
+	published := make(chan bool)
+	go func() {
+		bus.Publish(core.EventData{Event: "D"})
+		bus.Publish(core.EventData{Event: "E"})
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish did not block on a full subscription")
+	case <-time.After(time.Millisecond * 20):
+	}
+	receive(t, blocking)
+	<-published
+
+	bus.Close()
+	if _, ok := <-blocking.Events(); !ok {
+		t.Error("Expected buffered event before close")
+	}
+	if _, ok := <-blocking.Events(); ok {
+		t.Error("Expected closed subscription")
+	}
+	if _, ok := <-bus.Subscribe(nil, SubscriptionOptions{}).Events(); ok {
+		t.Error("Expected closed subscription after bus close")
+	}
+}
+
+func receive(t *testing.T, s *Subscription) core.EventData {
+	t.Helper()
+	select {
+	case evt := <-s.Events():
+		return evt
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+	return core.EventData{}
+}
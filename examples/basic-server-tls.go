@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/mungaij83/go-openvpn"
@@ -64,7 +65,7 @@ func main() {
 	p := openvpn.NewProcess(processFile, c)
 
 	managemnt := openvpn.NewVpnManagement("127.0.0.1", processFile, 17505, "", core.ServerMode)
-	err = managemnt.StartServer()
+	err = managemnt.StartServer(context.Background())
 	if err != nil {
 		glog.Error(err)
 		os.Exit(-1)
@@ -77,34 +78,29 @@ func main() {
 		glog.Error(err)
 		os.Exit(-3)
 	}
-	actioner := VpnDummyActions{}
+	// Clients are authenticated by the dispatcher, see DummyAuthenticator
+	auth := openvpn.NewAuthDispatcher(managemnt, DummyAuthenticator{})
+	go auth.Run(context.Background())
 	// Turn on real time Events
 	managemnt.Exec("status on")
 	managemnt.Exec("echo on")
-	managemnt.Exec(actioner.Usage(time.Second * 4))
+	if err = managemnt.ByteCount(4); err != nil {
+		glog.Error(err)
+	}
 	// Listen for events
+	events := managemnt.Subscribe(nil, openvpn.SubscriptionOptions{})
 	for {
 		select {
-		case event := <-managemnt.Events:
+		case event := <-events.Events():
 			nm := event.EventName()
 			switch nm {
-			case "CLIENT_CONNECT", "CLIENT_REAUTH":
-				glog.Infof("Authenticating Event: %s (%v)", event.Event, event.EventName())
-				pwd := event.Get("password")
-				user := event.Get("username")
-				if pwd == "test" && user == "test" {
-					glog.Infof("Authenticated Event: %s (%v) -> %s", event.Event, event.EventName(), user)
-					managemnt.Exec(actioner.Authenticated(event))
-				} else {
-					glog.Infof("Authentication Failed Event: %s (%v) -> %s", event.Event, event.EventName(), user)
-					managemnt.Exec(actioner.UnAuthenticated(event))
-				}
-				break
 			case "CLIENT_DISCONNECTED":
-				glog.V(1).Infof("Client closed session: %b", event.Data)
+				glog.V(1).Infof("Client closed session: %v", event.Data)
 				break
 			case "HOLD":
-				managemnt.Exec(actioner.HoldRelease())
+				if err = managemnt.HoldRelease(); err != nil {
+					glog.Error(err)
+				}
 				break
 			case "CLIENT_LIST":
 				clients, err := managemnt.GetClients(event)
@@ -115,7 +111,7 @@ func main() {
 				}
 				break
 			default:
-				glog.Infof("Other Events[%s]: %v", event.EventName(),event )
+				glog.Infof("Other Events[%s]: %v", event.EventName(), event)
 			}
 
 		}
@@ -1,33 +1,20 @@
 package main
 
 import (
-	"fmt"
-	"github.com/mungaij83/go-openvpn/core"
-	"time"
+	"context"
+	"github.com/mungaij83/go-openvpn"
 )
 
-type VpnDummyActions struct {
+// DummyAuthenticator accepts the user test with password test
+type DummyAuthenticator struct {
 }
 
-func (vd VpnDummyActions) HoldRelease() string {
-	return "hold release"
-}
-
-func (vd VpnDummyActions) Usage(duration time.Duration) string {
-	if duration.Seconds() > 0 {
-		return fmt.Sprintf("bytecount %d", int64(duration.Seconds()))
+func (da DummyAuthenticator) Authenticate(ctx context.Context, req *openvpn.AuthRequest) (*openvpn.AuthResult, error) {
+	if req.Username == "test" && req.Password == "test" {
+		return &openvpn.AuthResult{Allow: true}, nil
 	}
-	return "bytecount 0"
-}
-
-func (vd VpnDummyActions) UnAuthenticated(data core.EventData) string {
-	cid := data.Get("client_id")
-	ckid := data.Get("key_id")
-	return fmt.Sprintf("client-deny %s %s \"Invalid username or password\" [\"Invalid username or password\"]", cid, ckid)
-}
-
-func (vd VpnDummyActions) Authenticated(data core.EventData) string {
-	usr := data.Get("client_id")
-	ckid := data.Get("key_id")
-	return fmt.Sprintf("client-auth-nt %s %s", usr, ckid)
+	return &openvpn.AuthResult{
+		Reason:       "Invalid username or password",
+		ClientReason: "Invalid username or password",
+	}, nil
 }
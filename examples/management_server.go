@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/golang/glog"
 	"github.com/mungaij83/go-openvpn"
 	"github.com/mungaij83/go-openvpn/core"
@@ -50,7 +51,7 @@ func main() {
 
 	process := openvpn.NewProcess("", cfg)
 	managemnt := openvpn.NewVpnManagement(cfg.InterfaceAddress(), "", cfg.Port(), "", core.ServerMode)
-	err = managemnt.StartServer()
+	err = managemnt.StartServer(context.Background())
 	if err != nil {
 		glog.Errorf("Failed to connect: %v", err)
 		os.Exit(-1)
@@ -60,7 +61,8 @@ func main() {
 		glog.Error(err)
 		os.Exit(-1)
 	}
-	actioner := VpnDummyActions{}
+	auth := openvpn.NewAuthDispatcher(managemnt, DummyAuthenticator{})
+	events := managemnt.Subscribe(nil, openvpn.SubscriptionOptions{})
 	// Query status once every 5 seconds
 	timmer := time.NewTicker(time.Second * 5)
 	count := 0
@@ -75,27 +77,21 @@ func main() {
 				glog.Infof("Status: %v", st)
 			}
 			break
-		case event := <-managemnt.Events:
+		case event := <-events.Events():
 			glog.Infof("Event received: %+v", event)
 			nm := event.EventName()
 			switch nm {
 			case "CLIENT_CONNECT", "CLIENT_REAUTH":
 				glog.Infof("Authenticating Event: %s (%v)", event.Event, event.EventName())
-				pwd := event.Get("password")
-				user := event.Get("username")
-				if pwd == "test" && user == "test" {
-					glog.Infof("Authenticated Event: %s (%v) -> %s", event.Event, event.EventName(), user)
-					managemnt.Exec(actioner.Authenticated(event))
-				} else {
-					glog.Infof("Authentication Failed Event: %s (%v) -> %s", event.Event, event.EventName(), user)
-					managemnt.Exec(actioner.UnAuthenticated(event))
-				}
+				auth.Handle(context.Background(), event)
 				break
 			case "CLIENT_DISCONNECTED":
-				glog.V(1).Infof("Client closed session: %b", event.Data)
+				glog.V(1).Infof("Client closed session: %v", event.Data)
 				break
 			case "HOLD":
-				managemnt.Exec(actioner.HoldRelease())
+				if err = managemnt.HoldRelease(); err != nil {
+					glog.Error(err)
+				}
 				break
 			case "CLIENT_LIST":
 				clients, err := managemnt.GetClients(event)
@@ -111,5 +107,5 @@ func main() {
 			break
 		}
 	}
-	//managemnt.Shutdown()
+	//managemnt.Shutdown(context.Background())
 }
@@ -1,58 +1,70 @@
 package openvpn
 
 import (
+	"context"
 	log "github.com/cihub/seelog"
 	"github.com/golang/glog"
 	"github.com/mungaij83/go-openvpn/core"
-	"github.com/mungaij83/go-openvpn/utils"
+	"strings"
+	"sync"
 )
 
 type Management struct {
 	Conn          *Process
 	connector     core.OpenVpnConnector
 	Mode          int
-	Events        chan utils.Event `json:"-"`
+	bus           *EventBus
 	OpenVpnEvents chan string
 	clientEnv     map[string]string
 	shutdown      chan bool
+	shutdownOnce  sync.Once
 }
 
 func NewManagement(conn *Process, connector core.OpenVpnConnector) *Management {
 	return &Management{
 		connector:     connector,
 		Conn:          conn,
-		Events:        make(chan utils.Event),
+		bus:           NewEventBus(),
 		OpenVpnEvents: make(chan string, 10),
 		clientEnv:     make(map[string]string, 0),
 		shutdown:      make(chan bool),
 	}
 }
 
-func (m *Management) Start() error { // {{{
-	err := m.connector.Connect()
+func (m *Management) Start(ctx context.Context) error { // {{{
+	err := m.connector.Connect(ctx)
 	if err != nil {
 		return err
 	}
-	m.connector.Listen(m.OpenVpnEvents)
+	m.connector.Listen(context.Background(), m.OpenVpnEvents)
 	return nil
 }
 
+// Subscribe returns a subscription to the events fired by Fire
+func (m *Management) Subscribe(filter EventFilter, options SubscriptionOptions) *Subscription {
+	return m.bus.Subscribe(filter, options)
+}
+
+// Fire publishes an event to every subscription accepting it, the arguments
+// are joined in EventData and kept in Payload.
 func (m *Management) Fire(name string, args ...string) {
-	select {
-	case m.Events <- utils.Event{
-		Name: name,
-		Args: args,
-	}:
-	default:
-		glog.Warningf("Lost event: %v args: %v",  name,args)
-	}
+	m.bus.Publish(core.EventData{
+		Event:     name,
+		Completed: true,
+		Data:      make(map[string]string),
+		EventData: strings.Join(args, ","),
+		Payload:   args,
+	})
 }
 
 func (m *Management) Shutdown() {
-	log.Info("Management: shutdown")
-	close(m.shutdown)
-	err := m.connector.Close()
-	if err != nil {
-		glog.Error(err)
-	}
+	m.shutdownOnce.Do(func() {
+		log.Info("Management: shutdown")
+		close(m.shutdown)
+		m.bus.Close()
+		err := m.connector.Close()
+		if err != nil {
+			glog.Error(err)
+		}
+	})
 }
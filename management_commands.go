@@ -0,0 +1,324 @@
+package openvpn
+
+import (
+	"context"
+	"fmt"
+	"github.com/mungaij83/go-openvpn/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RemoteAccept = "ACCEPT"
+	RemoteSkip   = "SKIP"
+	RemoteModify = "MOD"
+
+	ProxyNone  = "NONE"
+	ProxyHTTP  = "HTTP"
+	ProxySocks = "SOCKS"
+)
+
+var validSignals = map[string]bool{
+	"SIGHUP":  true,
+	"SIGTERM": true,
+	"SIGUSR1": true,
+	"SIGUSR2": true,
+}
+
+// Commands sends management commands, its methods are promoted to
+// OpenVpnManagement. The commands of OpenVpnManagement.Instance are sent to
+// a single OpenVPN instance.
+type Commands struct {
+	// CommandTimeout bounds the commands sent without a context
+	CommandTimeout time.Duration
+	connection     core.OpenVpnConnector
+	ctx            context.Context
+	instance       string
+}
+
+// command sends a command and returns the parsed response. ERROR: responses
+// are returned as *core.CommandError.
+func (c *Commands) command(cmd string) (*core.Response, error) {
+	ctx, cancel := c.commandContext()
+	defer cancel()
+	return c.ExecContext(ctx, cmd)
+}
+
+func (c *Commands) commandLines(cmd string) ([]string, error) {
+	resp, err := c.command(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Lines, nil
+}
+
+func (c *Commands) commandErr(cmd string) error {
+	_, err := c.command(cmd)
+	return err
+}
+
+// multiLineCommand returns a command followed by lines and terminated by END
+func multiLineCommand(cmd string, lines []string) string {
+	b := strings.Builder{}
+	b.WriteString(cmd)
+	b.WriteString("\n")
+	for _, l := range lines {
+		b.WriteString(strings.TrimSpace(l))
+		b.WriteString("\n")
+	}
+	b.WriteString(core.ResponseEnd)
+	return b.String()
+}
+
+func checkClientIds(ids ...int) error {
+	for _, id := range ids {
+		if id < 0 {
+			return fmt.Errorf("invalid client id: %d", id)
+		}
+	}
+	return nil
+}
+
+// historyArg validates the argument of the echo, log and state commands
+func historyArg(arg string) error {
+	switch arg {
+	case "on", "off", "all", "on all":
+		return nil
+	}
+	if n, err := strconv.Atoi(arg); err != nil || n <= 0 {
+		return fmt.Errorf("invalid argument, expected on, off, all, on all or N: %q", arg)
+	}
+	return nil
+}
+
+// ClientAuth authorizes a client connection, config lines are pushed to the client
+func (c *Commands) ClientAuth(cid, kid int, config ...string) error {
+	if err := checkClientIds(cid, kid); err != nil {
+		return err
+	}
+	if err := core.ValidateArgs(config...); err != nil {
+		return err
+	}
+	return c.commandErr(multiLineCommand(fmt.Sprintf("client-auth %d %d", cid, kid), config))
+}
+
+// ClientAuthNT authorizes a client connection without pushing any config
+func (c *Commands) ClientAuthNT(cid, kid int) error {
+	if err := checkClientIds(cid, kid); err != nil {
+		return err
+	}
+	return c.commandErr(fmt.Sprintf("client-auth-nt %d %d", cid, kid))
+}
+
+// ClientDeny rejects a client connection, reason is logged by OpenVPN and
+// clientReason (if set) is sent to the client.
+func (c *Commands) ClientDeny(cid, kid int, reason, clientReason string) error {
+	if err := checkClientIds(cid, kid); err != nil {
+		return err
+	}
+	if err := core.ValidateArgs(reason, clientReason); err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf("client-deny %d %d %s", cid, kid, core.Quote(reason))
+	if clientReason != "" {
+		cmd = fmt.Sprintf("%s %s", cmd, core.Quote(clientReason))
+	}
+	return c.commandErr(cmd)
+}
+
+// ClientKill disconnects a client, message is optional (e.g. HALT or RESTART)
+func (c *Commands) ClientKill(cid int, message string) error {
+	if err := checkClientIds(cid); err != nil {
+		return err
+	}
+	if err := core.ValidateArgs(message); err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf("client-kill %d", cid)
+	if message != "" {
+		cmd = fmt.Sprintf("%s %s", cmd, core.Quote(message))
+	}
+	return c.commandErr(cmd)
+}
+
+// Kill disconnects clients by common name or real address (IP:port)
+func (c *Commands) Kill(target string) error {
+	if strings.TrimSpace(target) == "" {
+		return fmt.Errorf("kill requires a common name or address")
+	}
+	if err := core.ValidateArgs(target); err != nil {
+		return err
+	}
+	return c.commandErr(fmt.Sprintf("kill %s", core.Quote(target)))
+}
+
+func (c *Commands) HoldRelease() error {
+	return c.commandErr("hold release")
+}
+
+// ByteCount sets the interval in seconds of BYTECOUNT notifications, 0 disables them
+func (c *Commands) ByteCount(interval int) error {
+	if interval < 0 {
+		return fmt.Errorf("invalid bytecount interval: %d", interval)
+	}
+	return c.commandErr(fmt.Sprintf("bytecount %d", interval))
+}
+
+// Signal sends SIGHUP, SIGTERM, SIGUSR1 or SIGUSR2 to the daemon
+func (c *Commands) Signal(signal string) error {
+	if !validSignals[signal] {
+		return fmt.Errorf("invalid signal: %q", signal)
+	}
+	return c.commandErr(fmt.Sprintf("signal %s", signal))
+}
+
+func (c *Commands) Verb(level int) error {
+	if level < 0 || level > 11 {
+		return fmt.Errorf("invalid verbosity level: %d", level)
+	}
+	return c.commandErr(fmt.Sprintf("verb %d", level))
+}
+
+func (c *Commands) Mute(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid mute value: %d", n)
+	}
+	return c.commandErr(fmt.Sprintf("mute %d", n))
+}
+
+// Echo turns echo notifications on or off, or returns the history for all or N,
+// "on all" does both
+func (c *Commands) Echo(arg string) ([]string, error) {
+	if err := historyArg(arg); err != nil {
+		return nil, err
+	}
+	return c.commandLines(fmt.Sprintf("echo %s", arg))
+}
+
+// State turns state notifications on or off, or returns the history for all or
+// N, "on all" does both
+func (c *Commands) State(arg string) ([]string, error) {
+	if err := historyArg(arg); err != nil {
+		return nil, err
+	}
+	return c.commandLines(fmt.Sprintf("state %s", arg))
+}
+
+// Log turns log notifications on or off, or returns the history for all or N,
+// "on all" does both
+func (c *Commands) Log(arg string) ([]string, error) {
+	if err := historyArg(arg); err != nil {
+		return nil, err
+	}
+	return c.commandLines(fmt.Sprintf("log %s", arg))
+}
+
+// LoadStats returns the number of clients and the bytes transferred
+func (c *Commands) LoadStats() (*core.LoadStats, error) {
+	resp, err := c.command("load-stats")
+	if err != nil {
+		return nil, err
+	}
+	return core.ParseLoadStats(resp.Message)
+}
+
+// Pid returns the process id of OpenVPN
+func (c *Commands) Pid() (int, error) {
+	resp, err := c.command("pid")
+	if err != nil {
+		return 0, err
+	}
+	return core.ParsePid(resp.Message)
+}
+
+// Version returns the version of OpenVPN and of its management interface
+func (c *Commands) Version() (*core.VersionInfo, error) {
+	resp, err := c.command("version")
+	if err != nil {
+		return nil, err
+	}
+	return core.ParseVersion(resp.Lines)
+}
+
+// Remote answers a REMOTE notification, host and port are used by RemoteModify
+func (c *Commands) Remote(action, host string, port int) error {
+	switch action {
+	case RemoteAccept, RemoteSkip:
+		return c.commandErr(fmt.Sprintf("remote %s", action))
+	case RemoteModify:
+		if err := checkHostPort(host, port); err != nil {
+			return err
+		}
+		return c.commandErr(fmt.Sprintf("remote %s %s %d", action, host, port))
+	}
+	return fmt.Errorf("invalid remote action: %q", action)
+}
+
+// Proxy answers a PROXY notification, nct disallows cleartext auth for HTTP proxies
+func (c *Commands) Proxy(proxyType, host string, port int, nct bool) error {
+	switch proxyType {
+	case ProxyNone:
+		return c.commandErr(fmt.Sprintf("proxy %s", proxyType))
+	case ProxyHTTP, ProxySocks:
+		if err := checkHostPort(host, port); err != nil {
+			return err
+		}
+		cmd := fmt.Sprintf("proxy %s %s %d", proxyType, host, port)
+		if nct && proxyType == ProxyHTTP {
+			cmd += " nct"
+		}
+		return c.commandErr(cmd)
+	}
+	return fmt.Errorf("invalid proxy type: %q", proxyType)
+}
+
+func checkHostPort(host string, port int) error {
+	if host == "" || strings.ContainsAny(host, " \t\"\\") {
+		return fmt.Errorf("invalid host: %q", host)
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port: %d", port)
+	}
+	return core.ValidateArgs(host)
+}
+
+// Username answers a PASSWORD notification, authType is e.g. "Auth"
+func (c *Commands) Username(authType, username string) error {
+	if err := core.ValidateArgs(authType, username); err != nil {
+		return err
+	}
+	return c.commandErr(fmt.Sprintf("username %s %s", core.Quote(authType), core.Quote(username)))
+}
+
+// Password answers a PASSWORD notification, authType is e.g. "Auth" or "Private Key"
+func (c *Commands) Password(authType, password string) error {
+	if err := core.ValidateArgs(authType, password); err != nil {
+		return err
+	}
+	return c.commandErr(fmt.Sprintf("password %s %s", core.Quote(authType), core.Quote(password)))
+}
+
+// NeedOK answers a NEED-OK notification
+func (c *Commands) NeedOK(name string, ok bool) error {
+	if err := core.ValidateArgs(name); err != nil {
+		return err
+	}
+	answer := "cancel"
+	if ok {
+		answer = "ok"
+	}
+	return c.commandErr(fmt.Sprintf("needok %s %s", core.Quote(name), answer))
+}
+
+// NeedStr answers a NEED-STR notification
+func (c *Commands) NeedStr(name, value string) error {
+	if err := core.ValidateArgs(name, value); err != nil {
+		return err
+	}
+	return c.commandErr(fmt.Sprintf("needstr %s %s", core.Quote(name), core.Quote(value)))
+}
+
+func (c *Commands) ForgetPasswords() error {
+	return c.commandErr("forget-passwords")
+}
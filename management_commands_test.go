@@ -0,0 +1,191 @@
+package openvpn
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConnector records commands and answers them with a fixed response
+type fakeConnector struct {
+	lock     sync.Mutex
+	commands []string
+	response string
+	err      error
+}
+
+func (f *fakeConnector) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeConnector) SendCommand(ctx context.Context, cmd string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.commands = append(f.commands, cmd)
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.response, nil
+}
+
+func (f *fakeConnector) Listen(ctx context.Context, events chan string) {
+}
+
+func (f *fakeConnector) Close() error {
+	return nil
+}
+
+// waitCommands waits until n commands have been sent
+func (f *fakeConnector) waitCommands(t *testing.T, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		f.lock.Lock()
+		commands := append([]string{}, f.commands...)
+		f.lock.Unlock()
+		if len(commands) >= n {
+			return commands
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatalf("Timeout waiting for %d commands", n)
+	return nil
+}
+
+func newFakeManagement(response string) (*OpenVpnManagement, *fakeConnector) {
+	f := &fakeConnector{response: response}
+	vm := newManagement(f, core.ClientMode)
+	return vm, f
+}
+
+func TestCommandQuoting(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-deny command succeeded")
+	err := vm.ClientDeny(1, 2, `bad "password"`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.commands[0] != `client-deny 1 2 "bad \"password\""` {
+		t.Errorf("unexpected command: %s", f.commands[0])
+	}
+	if err = vm.Password("Auth", `pa\ss`); err != nil {
+		t.Fatal(err)
+	}
+	if f.commands[1] != `password "Auth" "pa\\ss"` {
+		t.Errorf("unexpected command: %s", f.commands[1])
+	}
+}
+
+func TestCommandValidation(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	if err := vm.Signal("SIGKILL"); err == nil {
+		t.Error("expected invalid signal error")
+	}
+	if err := vm.Username("Auth", "user\nsignal SIGTERM"); err == nil {
+		t.Error("expected invalid argument error")
+	}
+	if _, err := vm.State("on 10"); err == nil {
+		t.Error("expected invalid state argument error")
+	}
+	if err := vm.Remote(RemoteModify, "", 1194); err == nil {
+		t.Error("expected invalid host error")
+	}
+	if len(f.commands) != 0 {
+		t.Errorf("invalid commands were sent: %v", f.commands)
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	vm, _ := newFakeManagement("")
+	vm.connection.(*fakeConnector).err = &core.CommandError{Message: "client-kill command failed"}
+	err := vm.ClientKill(5, "")
+	if _, ok := err.(*core.CommandError); !ok {
+		t.Errorf("expected command error, got %v", err)
+	}
+}
+
+func TestClientAuthConfig(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-auth command succeeded")
+	err := vm.ClientAuth(3, 0, `push "route 10.0.0.0 255.255.255.0"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "client-auth 3 0\npush \"route 10.0.0.0 255.255.255.0\"\nEND"
+	if f.commands[0] != expected {
+		t.Errorf("unexpected command: %q", f.commands[0])
+	}
+}
+
+func TestStateLines(t *testing.T) {
+	vm, _ := newFakeManagement("1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,\nEND\n")
+	lines, err := vm.State("all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 {
+		t.Errorf("unexpected lines: %v", lines)
+	}
+}
+
+func TestStateOnAll(t *testing.T) {
+	vm, f := newFakeManagement("1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,\nEND\n")
+	lines, err := vm.State("on all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.commands[0] != "state on all" || len(lines) != 1 {
+		t.Errorf("unexpected command %q or lines %v", f.commands[0], lines)
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	vm, f := newFakeManagement("OpenVPN STATISTICS\nUpdated,Thu Feb 13 23:39:20 2014\nTCP/UDP read bytes,42\nEND\n")
+	st, err := vm.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Statistics == nil || st.Statistics.LinkReadBytes != 42 {
+		t.Errorf("Invalid status: %+v", st)
+	}
+	if f.commands[0] != "status 1" {
+		t.Errorf("Invalid command: %v", f.commands)
+	}
+	if _, err = vm.StatusVersion(4); err == nil {
+		t.Error("Expected error for status version 4")
+	}
+}
+
+func TestTypedResults(t *testing.T) {
+	vm, _ := newFakeManagement("SUCCESS: nclients=3,bytesin=1024,bytesout=2048")
+	stats, err := vm.LoadStats()
+	if err != nil || stats.Clients != 3 || stats.BytesIn != 1024 || stats.BytesOut != 2048 {
+		t.Errorf("Invalid load-stats: %+v, %v", stats, err)
+	}
+
+	vm, _ = newFakeManagement("SUCCESS: pid=4242")
+	if pid, err := vm.Pid(); err != nil || pid != 4242 {
+		t.Errorf("Invalid pid: %d, %v", pid, err)
+	}
+
+	vm, _ = newFakeManagement("OpenVPN Version: OpenVPN 2.6.3 x86_64-pc-linux-gnu [SSL (OpenSSL)] [LZO] [LZ4]\nManagement Version: 5\nEND\n")
+	v, err := vm.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Major != 2 || v.Minor != 6 || v.Patch != 3 || v.ManagementVersion != 5 || !v.SupportsPendingAuth() {
+		t.Errorf("Invalid version: %+v", v)
+	}
+	if (&core.VersionInfo{Major: 2, Minor: 5, ManagementVersion: 3}).SupportsChallengeResponse() {
+		t.Error("Management version 3 does not send CR_RESPONSE")
+	}
+
+	vm, _ = newFakeManagement("SUCCESS: nclients=x")
+	if _, err = vm.LoadStats(); err == nil {
+		t.Error("Expected error for invalid load-stats")
+	}
+	vm, f := newFakeManagement("")
+	f.err = core.ErrConnectionClosed
+	if _, err = vm.Pid(); err != core.ErrConnectionClosed {
+		t.Errorf("Expected connection error, got %v", err)
+	}
+}
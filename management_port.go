@@ -1,15 +1,22 @@
 package openvpn
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/golang/glog"
 	"github.com/mungaij83/go-openvpn/core"
-	"github.com/mungaij83/go-openvpn/utils"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// Flags are parsed by the program importing the package, parsing them in
+// init fails on the flags of go test that are not registered yet.
 func init() {
 	_ = flag.Set("alsologtostderr", "1")
-	flag.Parse()
 }
 
 const (
@@ -17,94 +24,365 @@ const (
 	UnixSocket = 1
 )
 
+// DefaultCommandTimeout bounds the commands sent without a context
+const DefaultCommandTimeout = time.Second * 30
+
 type OpenVpnManagement struct {
+	Commands
 	connectionType int
 	events         chan string
-	shutdown       chan bool
-	Events         chan core.EventData
+	lines          chan core.InstanceLine
+	cancel         context.CancelFunc
+	routinesLock   sync.Mutex
+	routines       sync.WaitGroup
+	shutdownOnce   sync.Once
+	stopped        chan bool
+	bus            *EventBus
 	parser         core.CommandParser
+	parsers        map[string]*core.CommandParser
 	mode           int
-	connection     core.OpenVpnConnector
+	stateLock      sync.Mutex
+	states         map[string]*core.StateEvent
+	stateChanged   chan bool
+	credentialLock sync.Mutex
+	credentials    CredentialProvider
+	challenge      *core.DynamicChallenge
+	signer         Signer
+	certificates   CertificateProvider
+	remotes        RemoteSelector
+	proxies        ProxySelector
 }
 
-func NewVpnManagement(ip string, socket string, port int, password string, mode int) OpenVpnManagement {
-	vpn := OpenVpnManagement{
-		events:   make(chan string),
-		Events:   make(chan core.EventData),
-		shutdown: make(chan bool),
-		mode:     mode,
-		parser:   core.NewCommandParser(),
-	}
+func NewVpnManagement(ip string, socket string, port int, password string, mode int) *OpenVpnManagement {
+	var vpn *OpenVpnManagement
 	// Initialize socket
 	if len(socket) > 0 {
+		vpn = newManagement(core.NewSocketConnector(socket, password, mode), mode)
 		vpn.connectionType = UnixSocket
-		vpn.connection = core.NewSocketConnector(socket, password, mode)
 	} else {
+		vpn = newManagement(core.NewTcpConnector(ip, port, password, mode), mode)
 		vpn.connectionType = TcpSocket
-		vpn.connection = core.NewTcpConnector(ip, port, password, mode)
 	}
 	return vpn
 }
 
-// Starts OpenVPN management interface in client mode
-// Events are sent by OpenVPN
-func (vm *OpenVpnManagement) StartClient() error {
-	err := vm.connection.Connect()
-	if err != nil {
-		return err
+// NewReconnectingVpnManagement returns a client mode management interface
+// that reconnects to OpenVPN when the connection is lost, commands (e.g.
+// "state on", "log on", "bytecount 5", "echo on") are sent after every
+// connect. The connection events are MANAGEMENT_CONNECTED and
+// MANAGEMENT_DISCONNECTED.
+func NewReconnectingVpnManagement(ip string, socket string, port int, password string, commands ...string) *OpenVpnManagement {
+	var vpn *OpenVpnManagement
+	if len(socket) > 0 {
+		vpn = newManagement(core.NewReconnectingConnector("unix", socket, password, commands...), core.ClientMode)
+		vpn.connectionType = UnixSocket
+	} else {
+		address := net.JoinHostPort(ip, strconv.Itoa(port))
+		vpn = newManagement(core.NewReconnectingConnector("tcp", address, password, commands...), core.ClientMode)
+		vpn.connectionType = TcpSocket
 	}
-	go vm.connection.Listen(vm.events)
+	return vpn
+}
 
-	return nil
+func newManagement(connection core.OpenVpnConnector, mode int) *OpenVpnManagement {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &OpenVpnManagement{
+		Commands: Commands{
+			CommandTimeout: DefaultCommandTimeout,
+			connection:     connection,
+			ctx:            ctx,
+		},
+		events:       make(chan string),
+		lines:        make(chan core.InstanceLine),
+		cancel:       cancel,
+		stopped:      make(chan bool),
+		bus:          NewEventBus(),
+		mode:         mode,
+		parser:       core.NewCommandParser(),
+		parsers:      make(map[string]*core.CommandParser),
+		states:       make(map[string]*core.StateEvent),
+		stateChanged: make(chan bool),
+	}
+}
+
+// Starts OpenVPN management interface in client mode
+// Events are sent by OpenVPN
+func (vm *OpenVpnManagement) StartClient(ctx context.Context) error {
+	return vm.start(ctx)
 }
 
 // Starts OpenVPN management interface in server mode
 // Events are poled from the server
-func (vm *OpenVpnManagement) StartServer() error {
-	// Start Connectors
-	err := vm.connection.Connect()
+func (vm *OpenVpnManagement) StartServer(ctx context.Context) error {
+	return vm.start(ctx)
+}
+
+// start connects within ctx, the connection then lives until Shutdown
+func (vm *OpenVpnManagement) start(ctx context.Context) error {
+	err := vm.connection.Connect(ctx)
 	if err != nil {
 		return err
 	}
+	if !vm.spawn(vm.dispatch) {
+		_ = vm.connection.Close()
+		return core.ErrConnectionClosed
+	}
+	if ic, ok := vm.connection.(core.InstanceConnector); ok {
+		ic.ListenInstances(vm.ctx, vm.lines)
+	} else {
+		vm.connection.Listen(vm.ctx, vm.events)
+	}
+	return nil
+}
 
+// spawn runs f in a goroutine joined by Shutdown, it returns false once the
+// management interface is shut down
+func (vm *OpenVpnManagement) spawn(f func()) bool {
+	vm.routinesLock.Lock()
+	defer vm.routinesLock.Unlock()
+	if vm.ctx.Err() != nil {
+		return false
+	}
+	vm.routines.Add(1)
 	go func() {
-		for {
-			select {
-			case e := <-vm.events:
-				evt := vm.parser.ParseEvent(e)
-				if evt != nil {
-					glog.V(3).Infof("EVENT: %+v", evt)
-					vm.Events <- *evt
-				}
-				break
-			case <-vm.shutdown:
-				glog.Infof("Shutdown server")
-				return
+		defer vm.routines.Done()
+		f()
+	}()
+	return true
+}
+
+// Subscribe returns a subscription to the events accepted by filter, e.g.
+// vm.Subscribe(EventKinds("STATE", "BYTECOUNT"), SubscriptionOptions{Buffer: 1000})
+func (vm *OpenVpnManagement) Subscribe(filter EventFilter, options SubscriptionOptions) *Subscription {
+	return vm.bus.Subscribe(filter, options)
+}
+
+// dispatch parses the lines received from OpenVPN and publishes them to the
+// subscribers, the lines of each instance have their own parser.
+func (vm *OpenVpnManagement) dispatch() {
+	for {
+		select {
+		case e := <-vm.events:
+			vm.publish(&vm.parser, "", e)
+		case l := <-vm.lines:
+			parser, ok := vm.parsers[l.Instance]
+			if !ok {
+				p := core.NewCommandParser()
+				parser = &p
+				vm.parsers[l.Instance] = parser
 			}
+			vm.publish(parser, l.Instance, l.Line)
+			if l.Line == core.ConnectionDown {
+				delete(vm.parsers, l.Instance)
+				vm.clearState(l.Instance)
+			}
+		case <-vm.ctx.Done():
+			glog.Infof("Shutdown server")
+			return
 		}
-	}()
-	// Start server if supported
-	go vm.connection.Listen(vm.events)
+	}
+}
+
+func (vm *OpenVpnManagement) publish(parser *core.CommandParser, instance string, line string) {
+	evt := parser.ParseEvent(line)
+	if evt == nil {
+		return
+	}
+	evt.Instance = instance
+	glog.V(3).Infof("EVENT: %+v", evt)
+	vm.handleEvent(evt)
+	vm.bus.Publish(*evt)
+}
+
+// Instance returns the commands sent to an OpenVPN instance connected in
+// server mode, see core.InstanceConnector. The empty ID selects the instance
+// that connected last.
+func (vm *OpenVpnManagement) Instance(id string) *Commands {
+	c := vm.Commands
+	c.instance = id
+	return &c
+}
+
+// SetTap records the lines exchanged with OpenVPN, e.g. with a
+// core.TranscriptWriter, it must be called before the management is started.
+func (vm *OpenVpnManagement) SetTap(tap core.Tap) error {
+	t, ok := vm.connection.(core.Tappable)
+	if !ok {
+		return errors.New("connection can not be tapped")
+	}
+	t.SetTap(tap)
+	return nil
+}
+
+// Instances returns the OpenVPN instances connected in server mode
+func (vm *OpenVpnManagement) Instances() []core.Instance {
+	if ic, ok := vm.connection.(core.InstanceConnector); ok {
+		return ic.Instances()
+	}
 	return nil
 }
 
-func (vm OpenVpnManagement) Status() (string, error) {
-	return vm.connection.SendCommand("status 1")
+func (vm *OpenVpnManagement) handleEvent(evt *core.EventData) {
+	if st, ok := evt.State(); ok {
+		vm.setState(evt.Instance, st)
+	}
+	vm.handleCredentials(evt)
+	vm.handleSign(evt)
+	vm.handleCertificate(evt)
+	vm.handleRemote(evt)
+}
+
+// setState records the last state of an instance, the client mode connection
+// has the empty instance ID.
+func (vm *OpenVpnManagement) setState(instance string, st *core.StateEvent) {
+	vm.stateLock.Lock()
+	defer vm.stateLock.Unlock()
+	vm.states[instance] = st
+	close(vm.stateChanged)
+	vm.stateChanged = make(chan bool)
+}
+
+// clearState forgets the state of a disconnected server mode instance
+func (vm *OpenVpnManagement) clearState(instance string) {
+	if instance == "" {
+		return
+	}
+	vm.stateLock.Lock()
+	defer vm.stateLock.Unlock()
+	delete(vm.states, instance)
+}
+
+// CurrentState returns the last state reported by OpenVPN in client mode, nil
+// if none was received
+func (vm *OpenVpnManagement) CurrentState() *core.StateEvent {
+	return vm.CurrentInstanceState("")
+}
+
+// CurrentInstanceState returns the last state reported by a server mode
+// instance, nil if none was received
+func (vm *OpenVpnManagement) CurrentInstanceState(instance string) *core.StateEvent {
+	vm.stateLock.Lock()
+	defer vm.stateLock.Unlock()
+	current, ok := vm.states[instance]
+	if !ok {
+		return nil
+	}
+	st := *current
+	return &st
+}
+
+// WaitForState blocks until OpenVPN reports state (e.g. core.StateConnected)
+// in client mode or ctx is done. State notifications must be enabled with
+// State("on").
+func (vm *OpenVpnManagement) WaitForState(ctx context.Context, state string) (*core.StateEvent, error) {
+	return vm.WaitForInstanceState(ctx, "", state)
+}
+
+// WaitForInstanceState blocks until a server mode instance reports state or
+// ctx is done, the states of the other instances are ignored.
+func (vm *OpenVpnManagement) WaitForInstanceState(ctx context.Context, instance string, state string) (*core.StateEvent, error) {
+	for {
+		vm.stateLock.Lock()
+		current, changed := vm.states[instance], vm.stateChanged
+		vm.stateLock.Unlock()
+		if current != nil && current.State == state {
+			st := *current
+			return &st, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Status returns the parsed output of "status", Statistics is set when
+// OpenVPN runs as a client and Clients when it runs as a server.
+func (c *Commands) Status() (*core.Status, error) {
+	return c.StatusVersion(1)
 }
 
-func (vm OpenVpnManagement) Shutdown() {
-	close(vm.shutdown)
-	err := vm.connection.Close()
+// StatusVersion returns the parsed output of "status 1", "status 2" or
+// "status 3"
+func (c *Commands) StatusVersion(version int) (*core.Status, error) {
+	parse := core.ParseStatus1
+	switch version {
+	case 1:
+	case 2:
+		parse = core.ParseStatus2
+	case 3:
+		parse = core.ParseStatus3
+	default:
+		return nil, fmt.Errorf("unsupported status version: %d", version)
+	}
+	resp, err := c.command(fmt.Sprintf("status %d", version))
 	if err != nil {
-		glog.V(2).Infof("Failed to close connection: %v", err)
+		return nil, err
+	}
+	return parse(resp.String())
+}
+
+// Shutdown closes the connection and waits until ctx is done for the
+// goroutines of the management interface, it may be called more than once.
+func (vm *OpenVpnManagement) Shutdown(ctx context.Context) error {
+	vm.shutdownOnce.Do(func() {
+		vm.routinesLock.Lock()
+		vm.cancel()
+		vm.routinesLock.Unlock()
+		vm.bus.Close()
+		go func() {
+			if err := vm.connection.Close(); err != nil {
+				glog.V(2).Infof("Failed to close connection: %v", err)
+			}
+			vm.routines.Wait()
+			close(vm.stopped)
+		}()
+	})
+	select {
+	case <-vm.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
-func (vm OpenVpnManagement) GetClients(data core.EventData) ([]utils.Client, error) {
+
+// GetClients returns the status carried by a CLIENT_LIST or STATUS event
+func (vm *OpenVpnManagement) GetClients(data core.EventData) (*core.Status, error) {
+	if st, ok := data.Status(); ok {
+		return st, nil
+	}
 	return vm.parser.ParseStatus(data.EventData)
 }
-func (vm *OpenVpnManagement) Exec(cmd string) {
-	_, err := vm.connection.SendCommand(cmd)
+func (c *Commands) Exec(cmd string) {
+	ctx, cancel := c.commandContext()
+	defer cancel()
+	_, err := c.ExecContext(ctx, cmd)
 	if err != nil {
 		glog.V(2).Infof("Failed to send command: %v", err)
 	}
 }
+
+// ExecContext sends a raw command and returns its response, it is safe to
+// call from many goroutines, responses are matched to the commands in order.
+func (c *Commands) ExecContext(ctx context.Context, cmd string) (*core.Response, error) {
+	var out string
+	var err error
+	if ic, ok := c.connection.(core.InstanceConnector); ok {
+		out, err = ic.SendCommandTo(ctx, c.instance, cmd)
+	} else if c.instance == "" {
+		out, err = c.connection.SendCommand(ctx, cmd)
+	} else {
+		err = core.ErrUnknownInstance
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := core.ParseResponse(out)
+	return resp, resp.Err()
+}
+
+// commandContext bounds a command by CommandTimeout, it is cancelled by Shutdown
+func (c *Commands) commandContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.ctx, c.CommandTimeout)
+}
@@ -1,9 +1,18 @@
 package openvpn
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"github.com/mungaij83/go-openvpn/core"
+	"github.com/mungaij83/go-openvpn/mgmttest"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 var waitGroup sync.WaitGroup
@@ -18,3 +27,253 @@ func TestNewManagement(t *testing.T) {
 }
 
 
+
+func TestWaitForState(t *testing.T) {
+	vm := newManagement(&fakeConnector{}, core.ClientMode)
+	go vm.dispatch()
+	defer vm.cancel()
+	go func() {
+		vm.events <- ">STATE:1392336000,WAIT,,,,,,"
+		vm.events <- ">STATE:1392336001,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,"
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	st, err := vm.WaitForState(ctx, core.StateConnected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.LocalIP != "10.8.0.6" {
+		t.Errorf("Invalid state: %+v", st)
+	}
+	if vm.CurrentState().State != core.StateConnected {
+		t.Errorf("Invalid current state: %+v", vm.CurrentState())
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err = vm.WaitForState(ctx, core.StateExiting); err != context.DeadlineExceeded {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+}
+
+func TestWaitForInstanceState(t *testing.T) {
+	vm := newManagement(&fakeConnector{}, core.ServerMode)
+	go vm.dispatch()
+	defer vm.cancel()
+	go func() {
+		vm.lines <- core.InstanceLine{Instance: "a", Line: ">STATE:1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,"}
+		vm.lines <- core.InstanceLine{Instance: "b", Line: ">STATE:1392336001,WAIT,,,,,,"}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := vm.WaitForInstanceState(ctx, "a", core.StateConnected); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if st, err := vm.WaitForInstanceState(ctx, "b", core.StateConnected); err != context.DeadlineExceeded {
+		t.Errorf("Expected timeout, got %+v: %v", st, err)
+	}
+	if st := vm.CurrentInstanceState("b"); st == nil || st.State != core.StateWait {
+		t.Errorf("Invalid state of b: %+v", st)
+	}
+	if st := vm.CurrentState(); st != nil {
+		t.Errorf("Unexpected client mode state: %+v", st)
+	}
+	vm.lines <- core.InstanceLine{Instance: "a", Line: core.ConnectionDown}
+	// Received once the disconnection is handled
+	vm.lines <- core.InstanceLine{Instance: "b", Line: ">STATE:1392336002,WAIT,,,,,,"}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := vm.WaitForInstanceState(ctx, "a", core.StateConnected); err != context.DeadlineExceeded {
+		t.Errorf("Expected the state of a disconnected instance to be cleared, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "management.sock")
+	vm := NewVpnManagement("", socket, 0, "", core.ServerMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = vm.StartServer(ctx); err != nil {
+		t.Fatal(err)
+	}
+	states := vm.Subscribe(EventKinds("STATE"), SubscriptionOptions{})
+
+	c, r := dialInstance(t, socket, 1234)
+	defer c.Close()
+	_, _ = c.Write([]byte(">STATE:1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,\n"))
+	if evt := receive(t, states); evt.Data["state"] != core.StateConnected {
+		t.Errorf("Unexpected event: %+v", evt)
+	}
+	go func() {
+		if line, _ := r.ReadString('\n'); line != "state on\n" {
+			t.Errorf("Unexpected command: %q", line)
+		}
+		_, _ = c.Write([]byte("SUCCESS: real-time state notification set to ON\n"))
+	}()
+	resp, err := vm.ExecContext(ctx, "state on")
+	if err != nil || resp.Message != "real-time state notification set to ON" {
+		t.Errorf("Unexpected response: %+v, %v", resp, err)
+	}
+
+	if err = vm.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = vm.Shutdown(ctx); err != nil {
+		t.Errorf("Second shutdown failed: %v", err)
+	}
+	if _, err = r.ReadString('\n'); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+	if _, ok := <-states.Events(); ok {
+		t.Error("Expected the subscription to be closed")
+	}
+	if err = vm.StartServer(ctx); err == nil {
+		t.Error("Expected start after shutdown to fail")
+	}
+}
+
+func TestServerModeCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "management.sock")
+	vm := NewVpnManagement("", socket, 0, "", core.ServerMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err = vm.StartServer(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Shutdown(ctx)
+
+	// Commands sent before OpenVPN connects wait for the connection
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(cid int) {
+			err := vm.ClientKill(cid, "")
+			if cid%2 == 1 {
+				if _, ok := err.(*core.CommandError); !ok {
+					err = fmt.Errorf("client %d: expected command error, got %v", cid, err)
+				} else {
+					err = nil
+				}
+			}
+			results <- err
+		}(i)
+	}
+	c, r := dialInstance(t, socket, 1234)
+	defer c.Close()
+	// Notifications are interleaved with the responses, odd clients do not exist
+	for i := 0; i < 10; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cid int
+		if _, err = fmt.Sscanf(line, "client-kill %d", &cid); err != nil {
+			t.Fatalf("Unexpected command: %q", line)
+		}
+		reply := fmt.Sprintf(">BYTECOUNT:%d,%d\nSUCCESS: client-kill command succeeded\n", cid, cid)
+		if cid%2 == 1 {
+			reply = fmt.Sprintf("ERROR: client-kill command failed\n>BYTECOUNT:%d,%d\n", cid, cid)
+		}
+		_, _ = c.Write([]byte(reply))
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "management.sock")
+	vm := NewVpnManagement("", socket, 0, "", core.ServerMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err = vm.StartServer(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vm.Shutdown(ctx)
+	events := vm.Subscribe(EventKinds("MANAGEMENT_CONNECTED", "MANAGEMENT_DISCONNECTED", "BYTECOUNT"), SubscriptionOptions{})
+
+	udp := mgmttest.New(t)
+	defer udp.Close()
+	udp.Pid = 100
+	udp.Dial("unix", socket)
+	if evt := receive(t, events); evt.EventName() != "MANAGEMENT_CONNECTED" || evt.Instance != "100" {
+		t.Errorf("Unexpected event: %+v", evt)
+	}
+	tcp := mgmttest.New(t)
+	defer tcp.Close()
+	tcp.Pid = 200
+	tcp.Dial("unix", socket)
+	if evt := receive(t, events); evt.EventName() != "MANAGEMENT_CONNECTED" || evt.Instance != "200" {
+		t.Errorf("Unexpected event: %+v", evt)
+	}
+	instances := vm.Instances()
+	if len(instances) != 2 || instances[0].ID != "100" || instances[1].Pid != 200 || !instances[1].Version.AtLeast(2, 6) {
+		t.Fatalf("Unexpected instances: %+v", instances)
+	}
+
+	tcp.Send(">BYTECOUNT:10,20")
+	if evt := receive(t, events); evt.EventName() != "BYTECOUNT" || evt.Instance != "200" {
+		t.Errorf("Unexpected event: %+v", evt)
+	}
+
+	// Commands are routed to the chosen instance
+	if err = vm.Instance("100").ClientKill(7, ""); err != nil {
+		t.Error(err)
+	}
+	udp.ExpectCommand("pid")
+	udp.ExpectCommand("version")
+	udp.ExpectCommand("client-kill 7")
+	if received := tcp.Received(); len(received) != 2 {
+		t.Errorf("Unexpected commands: %q", received)
+	}
+
+	tcp.Disconnect()
+	if evt := receive(t, events); evt.EventName() != "MANAGEMENT_DISCONNECTED" || evt.Instance != "200" {
+		t.Errorf("Unexpected event: %+v", evt)
+	}
+	if err = vm.Instance("200").ClientKill(7, ""); err != core.ErrUnknownInstance {
+		t.Errorf("Expected unknown instance, got %v", err)
+	}
+	if instances = vm.Instances(); len(instances) != 1 || instances[0].ID != "100" {
+		t.Errorf("Unexpected instances: %+v", instances)
+	}
+}
+
+// dialInstance connects to the listener as OpenVPN started with
+// --management-client, and answers the pid and version commands sent to
+// identify it.
+func dialInstance(t *testing.T, socket string, pid int) (net.Conn, *bufio.Reader) {
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(c)
+	replies := []string{
+		fmt.Sprintf("SUCCESS: pid=%d\n", pid),
+		"OpenVPN Version: OpenVPN 2.6.3 x86_64-pc-linux-gnu\nManagement Version: 5\nEND\n",
+	}
+	for _, reply := range replies {
+		if _, err = r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = c.Write([]byte(reply))
+	}
+	return c, r
+}
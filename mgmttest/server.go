@@ -0,0 +1,403 @@
+// Package mgmttest provides a fake OpenVPN management interface for tests.
+// It speaks the management protocol over TCP or a unix socket, either
+// listening like --management or dialing a --management-client listener,
+// so connectors and handlers can be tested without an openvpn binary.
+package mgmttest
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// Banner is sent by OpenVPN once a management client is connected
+	Banner         = ">INFO:OpenVPN Management Interface Version 5 -- type 'help' for more info"
+	DefaultTimeout = time.Second * 2
+	passwordPrompt = "ENTER PASSWORD:"
+)
+
+// multiLineCommands are followed by lines terminated by END
+var multiLineCommands = map[string]bool{
+	"client-auth": true,
+	"certificate": true,
+	"pk-sig":      true,
+	"rsa-sig":     true,
+}
+
+// Handler returns the lines written in reply to a command, e.g.
+// "SUCCESS: pid=1234", or the lines of a multi-line response and END.
+// Notifications may be interleaved with the response.
+type Handler func(command string) []string
+
+// Reply answers every command with lines
+func Reply(lines ...string) Handler {
+	return func(string) []string {
+		return lines
+	}
+}
+
+// Success answers "SUCCESS: msg"
+func Success(msg string) Handler {
+	return Reply("SUCCESS: " + msg)
+}
+
+// Error answers "ERROR: msg"
+func Error(msg string) Handler {
+	return Reply("ERROR: " + msg)
+}
+
+// Server is a fake OpenVPN management interface. It answers pid and version
+// with Pid and Version, "state on all" with an empty history and commands
+// without a handler with "SUCCESS: <command> command succeeded". Every
+// received command is recorded, multi-line commands (client-auth,
+// certificate, pk-sig) with their lines.
+type Server struct {
+	Pid     int
+	Version string
+	// Password is asked before the banner when it is set
+	Password string
+	// Timeout bounds the waits for a connection or a command
+	Timeout   time.Duration
+	t         testing.TB
+	lock      sync.Mutex
+	handlers  map[string]Handler
+	received  []string
+	next      int
+	arrived   chan bool
+	conn      net.Conn
+	ready     chan bool
+	conns     map[net.Conn]bool
+	writeLock sync.Mutex
+	listener  net.Listener
+	dir       string
+	closed    bool
+	routines  sync.WaitGroup
+}
+
+// New returns a server reporting failures to t, it must be closed with Close
+func New(t testing.TB) *Server {
+	return &Server{
+		Pid:      1,
+		Version:  "OpenVPN 2.6.3 x86_64-pc-linux-gnu [SSL (OpenSSL)] [LZ4] [EPOLL] [MH/PKTINFO] [AEAD]",
+		Timeout:  DefaultTimeout,
+		t:        t,
+		handlers: make(map[string]Handler),
+		received: make([]string, 0),
+		arrived:  make(chan bool),
+		ready:    make(chan bool),
+		conns:    make(map[net.Conn]bool),
+	}
+}
+
+// Handle sets the handler of a command by name, e.g. "status" or "client-auth"
+func (s *Server) Handle(name string, handler Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[name] = handler
+}
+
+// Listen accepts management clients on address like OpenVPN started with
+// --management, and returns the address. An empty address listens on a
+// random port of 127.0.0.1 for "tcp", and on a temporary socket for "unix".
+// Clients are served one at a time, a client may connect again once the
+// previous one is gone.
+func (s *Server) Listen(network, address string) string {
+	s.t.Helper()
+	if address == "" {
+		address = s.defaultAddress(network)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		s.t.Fatalf("mgmttest: listen failed: %v", err)
+	}
+	s.lock.Lock()
+	s.listener = l
+	s.lock.Unlock()
+	s.spawn(func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(conn)
+		}
+	})
+	return l.Addr().String()
+}
+
+func (s *Server) defaultAddress(network string) string {
+	if network != "unix" {
+		return "127.0.0.1:0"
+	}
+	dir, err := ioutil.TempDir("", "mgmttest")
+	if err != nil {
+		s.t.Fatalf("mgmttest: %v", err)
+	}
+	s.lock.Lock()
+	s.dir = dir
+	s.lock.Unlock()
+	return filepath.Join(dir, "management.sock")
+}
+
+// Dial connects to a management client listening on address like OpenVPN
+// started with --management-client
+func (s *Server) Dial(network, address string) {
+	s.t.Helper()
+	conn, err := net.DialTimeout(network, address, s.Timeout)
+	if err != nil {
+		s.t.Fatalf("mgmttest: dial failed: %v", err)
+	}
+	s.spawn(func() { s.serve(conn) })
+}
+
+func (s *Server) spawn(f func()) {
+	s.routines.Add(1)
+	go func() {
+		defer s.routines.Done()
+		f()
+	}()
+}
+
+// serve authenticates the client, sends the banner and answers the commands
+// until the connection is closed
+func (s *Server) serve(conn net.Conn) {
+	if !s.track(conn) {
+		_ = conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+	r := bufio.NewReader(conn)
+	if s.Password != "" {
+		_, _ = conn.Write([]byte(passwordPrompt))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.TrimRight(line, "\r\n") != s.Password {
+			_, _ = conn.Write([]byte("ERROR: bad password\n"))
+			return
+		}
+		_, _ = conn.Write([]byte("SUCCESS: password is correct\n"))
+	}
+	s.setConn(conn)
+	defer s.clearConn(conn)
+	s.write(conn, Banner)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.record(cmd)
+		s.write(conn, s.handler(cmd)(cmd)...)
+	}
+}
+
+// readCommand reads a command and, for multi-line commands, its lines up to END
+func readCommand(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	cmd := strings.TrimRight(line, "\r\n")
+	if !multiLineCommands[commandName(cmd)] {
+		return cmd, nil
+	}
+	lines := []string{cmd}
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+		if line == "END" {
+			return strings.Join(lines, "\n"), nil
+		}
+	}
+}
+
+func commandName(cmd string) string {
+	if i := strings.IndexAny(cmd, " \n"); i >= 0 {
+		return cmd[:i]
+	}
+	return cmd
+}
+
+func (s *Server) handler(cmd string) Handler {
+	name := commandName(cmd)
+	s.lock.Lock()
+	h, ok := s.handlers[name]
+	s.lock.Unlock()
+	if ok {
+		return h
+	}
+	switch name {
+	case "pid":
+		return Success(fmt.Sprintf("pid=%d", s.Pid))
+	case "version":
+		return Reply("OpenVPN Version: "+s.Version, "Management Version: 5", "END")
+	case "echo", "log", "state":
+		if strings.HasSuffix(cmd, " on all") {
+			return Reply("SUCCESS: real-time "+name+" notification set to ON", "END")
+		}
+	}
+	return Success(name + " command succeeded")
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = true
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.lock.Lock()
+	delete(s.conns, conn)
+	s.lock.Unlock()
+	_ = conn.Close()
+}
+
+func (s *Server) setConn(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == nil {
+		close(s.ready)
+	}
+	s.conn = conn
+}
+
+func (s *Server) clearConn(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == conn {
+		s.conn = nil
+		s.ready = make(chan bool)
+	}
+}
+
+func (s *Server) record(cmd string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.received = append(s.received, cmd)
+	close(s.arrived)
+	s.arrived = make(chan bool)
+}
+
+func (s *Server) write(conn net.Conn, lines ...string) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	for _, line := range lines {
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+// WaitConnected waits until a management client is connected and
+// authenticated
+func (s *Server) WaitConnected() {
+	s.t.Helper()
+	s.connection()
+}
+
+func (s *Server) connection() net.Conn {
+	s.t.Helper()
+	timer := time.NewTimer(s.Timeout)
+	defer timer.Stop()
+	for {
+		s.lock.Lock()
+		conn, ready := s.conn, s.ready
+		s.lock.Unlock()
+		if conn != nil {
+			return conn
+		}
+		select {
+		case <-ready:
+		case <-timer.C:
+			s.t.Fatalf("mgmttest: no management client connected within %v", s.Timeout)
+		}
+	}
+}
+
+// Send writes lines, e.g. notifications, to the connected client
+func (s *Server) Send(lines ...string) {
+	s.t.Helper()
+	s.write(s.connection(), lines...)
+}
+
+// Disconnect closes the connection of the client, like OpenVPN does when it
+// restarts
+func (s *Server) Disconnect() {
+	s.t.Helper()
+	_ = s.connection().Close()
+}
+
+// NextCommand waits for the next command not returned yet
+func (s *Server) NextCommand() string {
+	s.t.Helper()
+	timer := time.NewTimer(s.Timeout)
+	defer timer.Stop()
+	for {
+		s.lock.Lock()
+		if s.next < len(s.received) {
+			cmd := s.received[s.next]
+			s.next++
+			s.lock.Unlock()
+			return cmd
+		}
+		arrived := s.arrived
+		s.lock.Unlock()
+		select {
+		case <-arrived:
+		case <-timer.C:
+			s.t.Fatalf("mgmttest: no command received within %v", s.Timeout)
+		}
+	}
+}
+
+// ExpectCommand fails the test unless the next command is expected
+func (s *Server) ExpectCommand(expected string) {
+	s.t.Helper()
+	if cmd := s.NextCommand(); cmd != expected {
+		s.t.Errorf("mgmttest: expected command %q, got %q", expected, cmd)
+	}
+}
+
+// Received returns every command received so far
+func (s *Server) Received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+// Close closes the listener and the connections and waits for them to be
+// served, it may be called more than once
+func (s *Server) Close() {
+	s.lock.Lock()
+	s.closed = true
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	dir := s.dir
+	s.dir = ""
+	s.lock.Unlock()
+	s.routines.Wait()
+	if dir != "" {
+		_ = os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,153 @@
+package mgmttest_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"github.com/mungaij83/go-openvpn/mgmttest"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMode(t *testing.T) {
+	srv := mgmttest.New(t)
+	defer srv.Close()
+	srv.Password = "secret"
+	srv.Handle("status", mgmttest.Reply(">BYTECOUNT:1,2", "OpenVPN STATISTICS", "END"))
+	srv.Handle("kill", mgmttest.Error("common name 'nobody' not found"))
+	host, port, err := net.SplitHostPort(srv.Listen("tcp", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+
+	c := core.NewTcpConnector(host, n, "secret", core.ClientMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	events := make(chan string, 10)
+	c.Listen(ctx, events)
+
+	if out, err := c.SendCommand(ctx, "status"); err != nil || !strings.HasPrefix(out, "OpenVPN STATISTICS\n") {
+		t.Errorf("Unexpected response: %q, %v", out, err)
+	}
+	if _, err := c.SendCommand(ctx, "kill nobody"); err == nil {
+		t.Error("Expected command error")
+	}
+	if _, err := c.SendCommand(ctx, "client-auth 1 2\npush \"route 10.0.0.0 255.0.0.0\"\nEND"); err != nil {
+		t.Error(err)
+	}
+	srv.ExpectCommand("status")
+	srv.ExpectCommand("kill nobody")
+	srv.ExpectCommand("client-auth 1 2\npush \"route 10.0.0.0 255.0.0.0\"\nEND")
+
+	srv.Send(">STATE:1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,")
+	for _, expected := range []string{mgmttest.Banner, ">BYTECOUNT:1,2", ">STATE:1392336000"} {
+		select {
+		case e := <-events:
+			if !strings.HasPrefix(e, expected) {
+				t.Errorf("Expected %q, got %q", expected, e)
+			}
+		case <-ctx.Done():
+			t.Fatal("Timeout waiting for notification")
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mgmttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "management.sock")
+	l := core.NewSocketConnector(socket, "", core.ServerMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := l.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ic := l.(core.InstanceConnector)
+	lines := make(chan core.InstanceLine, 10)
+	ic.ListenInstances(ctx, lines)
+
+	srv := mgmttest.New(t)
+	defer srv.Close()
+	srv.Pid = 4242
+	srv.Dial("unix", socket)
+	srv.ExpectCommand("pid")
+	srv.ExpectCommand("version")
+	if l := <-lines; l.Instance != "4242" || l.Line != core.ConnectionUp {
+		t.Errorf("Unexpected line: %+v", l)
+	}
+	if err := srv.Replay(strings.NewReader("# comment\n\n>HOLD:Waiting for hold release:0\n")); err != nil {
+		t.Fatal(err)
+	}
+	if l := <-lines; l.Line != ">INFO:OpenVPN Management Interface Version 5 -- type 'help' for more info" {
+		t.Errorf("Unexpected line: %+v", l)
+	}
+	if l := <-lines; l.Line != ">HOLD:Waiting for hold release:0" {
+		t.Errorf("Unexpected line: %+v", l)
+	}
+	if _, err := ic.SendCommandTo(ctx, "4242", "hold release"); err != nil {
+		t.Error(err)
+	}
+	srv.ExpectCommand("hold release")
+	srv.Disconnect()
+	if l := <-lines; l.Line != core.ConnectionDown {
+		t.Errorf("Unexpected line: %+v", l)
+	}
+}
+
+func TestReplayTranscript(t *testing.T) {
+	var buffer bytes.Buffer
+	tw := core.NewTranscriptWriter(&buffer)
+	for _, e := range []core.TranscriptEntry{
+		{Direction: core.DirectionWrite, Line: "state on"},
+		{Direction: core.DirectionRead, Line: "SUCCESS: real-time state notification set to ON"},
+		{Direction: core.DirectionRead, Line: ">STATE:1392336000,CONNECTED,SUCCESS,10.8.0.6,1.2.3.4,1194,,"},
+		{Direction: core.DirectionRead, Line: ">CLIENT:CR_RESPONSE,8,2,MTIzNDU2"},
+	} {
+		e.Time = time.Now()
+		tw.Record(e)
+	}
+
+	srv := mgmttest.New(t)
+	defer srv.Close()
+	c := core.NewSocketConnector(srv.Listen("unix", ""), "", core.ClientMode)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	events := make(chan string, 10)
+	c.Listen(ctx, events)
+	if err := srv.ReplayTranscript(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	// The response to the challenge is redacted by the writer
+	for _, expected := range []string{mgmttest.Banner, ">STATE:1392336000,CONNECTED", ">CLIENT:CR_RESPONSE,8,2,***"} {
+		select {
+		case e := <-events:
+			if !strings.HasPrefix(e, expected) {
+				t.Errorf("Expected %q, got %q", expected, e)
+			}
+		case <-ctx.Done():
+			t.Fatal("Timeout waiting for notification")
+		}
+	}
+	if err := srv.ReplayTranscript(strings.NewReader("not json\n")); err == nil {
+		t.Error("Expected an invalid transcript error")
+	}
+}
@@ -0,0 +1,92 @@
+package mgmttest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// transcriptEntry is a line of the JSON transcripts written by
+// core.TranscriptWriter, core is not imported since its tests use mgmttest.
+type transcriptEntry struct {
+	Direction string `json:"direction"`
+	Line      string `json:"line"`
+}
+
+// Replay sends the lines of a plain text transcript to the connected client,
+// see ReplayTranscript for the JSON transcripts of core.TranscriptWriter.
+// Every line is sent as is (e.g. ">CLIENT:CONNECT,0,1" and its ">CLIENT:ENV"
+// lines) except empty lines and comments starting with '#'.
+func (s *Server) Replay(r io.Reader) error {
+	s.t.Helper()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	s.Send(lines...)
+	return nil
+}
+
+// ReplayFile sends the transcript stored in path, see Replay
+func (s *Server) ReplayFile(path string) error {
+	s.t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Replay(f)
+}
+
+// ReplayTranscript sends the notifications of a JSON transcript written by
+// core.TranscriptWriter, i.e. the lines read from OpenVPN starting with '>'.
+// Commands and responses are not replayed, commands are answered by the
+// handlers of the server.
+func (s *Server) ReplayTranscript(r io.Reader) error {
+	s.t.Helper()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry transcriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("invalid transcript line %d: %v", n, err)
+		}
+		if entry.Direction == "read" && strings.HasPrefix(entry.Line, ">") {
+			lines = append(lines, entry.Line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	s.Send(lines...)
+	return nil
+}
+
+// ReplayTranscriptFile sends the notifications of the JSON transcript stored
+// in path, see ReplayTranscript
+func (s *Server) ReplayTranscriptFile(path string) error {
+	s.t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.ReplayTranscript(f)
+}
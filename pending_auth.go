@@ -0,0 +1,190 @@
+package openvpn
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+	"sync"
+	"time"
+)
+
+const DefaultPendingAuthTimeout = time.Second * 120
+
+var ErrNoPendingAuth = errors.New("no pending authentication")
+
+// PendingOptions defers an authentication, the client is told with Extra how
+// to complete it out of band (see WebAuthExtra and ChallengeExtra).
+type PendingOptions struct {
+	Extra   string
+	Timeout time.Duration
+}
+
+// PendingAuth is a client waiting for an out of band authentication
+type PendingAuth struct {
+	Instance string
+	ClientID int
+	KeyID    int
+	Request  *AuthRequest
+	Extra    string
+	Deadline time.Time
+	timer    *time.Timer
+}
+
+type pendingKey struct {
+	instance string
+	cid      int
+	kid      int
+}
+
+// PendingAuthRegistry keeps the clients sent a client-pending-auth until they
+// are completed, denied or time out. It is safe for concurrent use, e.g. to
+// complete an authentication from an HTTP callback.
+type PendingAuthRegistry struct {
+	management *OpenVpnManagement
+	lock       sync.Mutex
+	pending    map[pendingKey]*PendingAuth
+	// afterFunc arms the timeout of a pending client, it is replaced in tests
+	afterFunc func(d time.Duration, f func()) *time.Timer
+}
+
+func NewPendingAuthRegistry(vm *OpenVpnManagement) *PendingAuthRegistry {
+	return &PendingAuthRegistry{
+		management: vm,
+		pending:    make(map[pendingKey]*PendingAuth),
+		afterFunc:  time.AfterFunc,
+	}
+}
+
+// WebAuthExtra asks the client to open url to authenticate
+func WebAuthExtra(url string) string {
+	return "WEB_AUTH::" + url
+}
+
+// ChallengeExtra asks the client to answer a challenge (e.g. an OTP), the
+// response is received as a CR_RESPONSE client event.
+func ChallengeExtra(text string, echo bool) string {
+	flags := "R"
+	if echo {
+		flags = "E,R"
+	}
+	return fmt.Sprintf("CR_TEXT:%s:%s", flags, text)
+}
+
+// ClientPendingAuth tells a client to complete authentication out of band
+// within timeout
+func (c *Commands) ClientPendingAuth(cid, kid int, extra string, timeout time.Duration) error {
+	if err := checkClientIds(cid, kid); err != nil {
+		return err
+	}
+	if extra == "" {
+		return fmt.Errorf("invalid pending auth extra: %q", extra)
+	}
+	if err := core.ValidateArgs(extra); err != nil {
+		return err
+	}
+	if timeout < time.Second {
+		return fmt.Errorf("invalid pending auth timeout: %v", timeout)
+	}
+	return c.commandErr(fmt.Sprintf("client-pending-auth %d %d %s %d", cid, kid, core.Quote(extra), int(timeout.Seconds())))
+}
+
+// Start registers req and sends client-pending-auth, the client is denied if
+// it is not completed before the timeout. The registration is removed when
+// the command fails.
+func (r *PendingAuthRegistry) Start(req *AuthRequest, options PendingOptions) (*PendingAuth, error) {
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = DefaultPendingAuthTimeout
+	}
+	key := pendingKey{req.Instance, req.ClientID, req.KeyID}
+	pa := &PendingAuth{
+		Instance: req.Instance,
+		ClientID: req.ClientID,
+		KeyID:    req.KeyID,
+		Request:  req,
+		Extra:    options.Extra,
+		Deadline: time.Now().Add(timeout),
+	}
+	// Registered first, a CR_RESPONSE may arrive before the command returns
+	r.lock.Lock()
+	if old, ok := r.pending[key]; ok {
+		old.timer.Stop()
+	}
+	r.pending[key] = pa
+	pa.timer = r.afterFunc(timeout, func() {
+		if err := r.Deny(key.instance, key.cid, key.kid, "authentication timeout", ""); err != nil && err != ErrNoPendingAuth {
+			glog.Errorf("Failed to deny client %d after timeout: %v", key.cid, err)
+		}
+	})
+	r.lock.Unlock()
+
+	err := r.management.Instance(req.Instance).ClientPendingAuth(req.ClientID, req.KeyID, options.Extra, timeout)
+	if err != nil {
+		r.lock.Lock()
+		if r.pending[key] == pa {
+			pa.timer.Stop()
+			delete(r.pending, key)
+		}
+		r.lock.Unlock()
+		return nil, err
+	}
+	return pa, nil
+}
+
+// Get returns the pending authentication of a client of instance, which is
+// empty unless several OpenVPN instances are connected (see AuthRequest)
+func (r *PendingAuthRegistry) Get(instance string, cid, kid int) (*PendingAuth, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pa, ok := r.pending[pendingKey{instance, cid, kid}]
+	return pa, ok
+}
+
+func (r *PendingAuthRegistry) take(instance string, cid, kid int) (*PendingAuth, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := pendingKey{instance, cid, kid}
+	pa, ok := r.pending[key]
+	if !ok {
+		return nil, ErrNoPendingAuth
+	}
+	pa.timer.Stop()
+	delete(r.pending, key)
+	return pa, nil
+}
+
+// Complete authorizes a pending client, config may be nil
+func (r *PendingAuthRegistry) Complete(instance string, cid, kid int, config *ClientConfig) error {
+	if _, err := r.take(instance, cid, kid); err != nil {
+		return err
+	}
+	return r.management.Instance(instance).clientAllow(cid, kid, config)
+}
+
+// Deny rejects a pending client
+func (r *PendingAuthRegistry) Deny(instance string, cid, kid int, reason, clientReason string) error {
+	if _, err := r.take(instance, cid, kid); err != nil {
+		return err
+	}
+	return r.management.Instance(instance).ClientDeny(cid, kid, reason, clientReason)
+}
+
+// Remove forgets every pending authentication of a disconnected client
+func (r *PendingAuthRegistry) Remove(instance string, cid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, pa := range r.pending {
+		if key.instance == instance && key.cid == cid {
+			pa.timer.Stop()
+			delete(r.pending, key)
+		}
+	}
+}
+
+// Len returns the number of pending authentications
+func (r *PendingAuthRegistry) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.pending)
+}
@@ -0,0 +1,128 @@
+package openvpn
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"testing"
+	"time"
+)
+
+type webAuthenticator struct{}
+
+func (wa webAuthenticator) Authenticate(ctx context.Context, req *AuthRequest) (*AuthResult, error) {
+	return &AuthResult{Pending: &PendingOptions{
+		Extra:   WebAuthExtra("https://vpn.example.com/auth?user=" + req.Username),
+		Timeout: time.Second * 60,
+	}}, nil
+}
+
+func TestPendingAuthComplete(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: client-pending-auth command succeeded")
+	ad := NewAuthDispatcher(vm, webAuthenticator{})
+	ad.Handle(context.Background(), clientConnect(t, 5, 1, "user", "", "user"))
+	ad.Wait()
+	commands := f.waitCommands(t, 1)
+	if commands[0] != `client-pending-auth 5 1 "WEB_AUTH::https://vpn.example.com/auth?user=user" 60` {
+		t.Errorf("Unexpected command: %q", commands[0])
+	}
+	if _, ok := ad.Pending.Get("", 5, 1); !ok {
+		t.Fatal("Client not pending")
+	}
+	done := make(chan error)
+	go func() {
+		done <- ad.Pending.Complete("", 5, 1, nil)
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	commands = f.waitCommands(t, 2)
+	if commands[1] != "client-auth-nt 5 1" {
+		t.Errorf("Unexpected command: %q", commands[1])
+	}
+	if err := ad.Pending.Deny("", 5, 1, "late", ""); err != ErrNoPendingAuth {
+		t.Errorf("Expected no pending auth, got %v", err)
+	}
+}
+
+func TestPendingAuthTimeout(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	r := NewPendingAuthRegistry(vm)
+	var expire func()
+	r.afterFunc = func(d time.Duration, f func()) *time.Timer {
+		if d != time.Second {
+			t.Errorf("Unexpected timeout: %v", d)
+		}
+		expire = f
+		return time.NewTimer(time.Hour)
+	}
+	req := &AuthRequest{ClientID: 6, KeyID: 0}
+	if _, err := r.Start(req, PendingOptions{Extra: ChallengeExtra("Enter OTP", false), Timeout: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	commands := f.waitCommands(t, 1)
+	if commands[0] != `client-pending-auth 6 0 "CR_TEXT:R:Enter OTP" 1` {
+		t.Errorf("Unexpected command: %q", commands[0])
+	}
+	expire()
+	commands = f.waitCommands(t, 2)
+	if commands[1] != `client-deny 6 0 "authentication timeout"` {
+		t.Errorf("Unexpected command: %q", commands[1])
+	}
+	if r.Len() != 0 {
+		t.Error("Expired client still pending")
+	}
+}
+
+func TestPendingAuthStartFailure(t *testing.T) {
+	vm, f := newFakeManagement("")
+	f.err = &core.CommandError{Message: "client-pending-auth command failed"}
+	r := NewPendingAuthRegistry(vm)
+	req := &AuthRequest{ClientID: 7, KeyID: 0}
+	if _, err := r.Start(req, PendingOptions{Extra: WebAuthExtra("https://vpn.example.com/auth")}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, ok := r.Get("", 7, 0); ok || r.Len() != 0 {
+		t.Error("Failed client still pending")
+	}
+}
+
+type otpAuthenticator struct{}
+
+func (oa otpAuthenticator) Authenticate(ctx context.Context, req *AuthRequest) (*AuthResult, error) {
+	return &AuthResult{Pending: &PendingOptions{Extra: ChallengeExtra("Enter your OTP", false)}}, nil
+}
+
+func (oa otpAuthenticator) ChallengeResponse(ctx context.Context, pending *PendingAuth, response string) (*AuthResult, error) {
+	if pending.Request.Username == "user" && response == "123456" {
+		return &AuthResult{Allow: true}, nil
+	}
+	return &AuthResult{Reason: "invalid OTP"}, nil
+}
+
+func TestChallengeResponse(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: ok")
+	ad := NewAuthDispatcher(vm, otpAuthenticator{})
+	ctx := context.Background()
+	ad.Handle(ctx, clientConnect(t, 8, 2, "user", "secret", "user"))
+	ad.Wait()
+
+	p := core.NewCommandParser()
+	var evt *core.EventData
+	for _, l := range []string{">CLIENT:CR_RESPONSE,8,2,MTIzNDU2", ">CLIENT:ENV,common_name=user", ">CLIENT:ENV,END"} {
+		evt = p.ParseEvent(l)
+	}
+	if evt == nil || evt.Get("cr_response") != "123456" {
+		t.Fatalf("Invalid challenge response event: %+v", evt)
+	}
+	if !ad.Handle(ctx, *evt) {
+		t.Fatal("Challenge response not handled")
+	}
+	ad.Wait()
+	commands := f.waitCommands(t, 2)
+	if commands[0] != `client-pending-auth 8 2 "CR_TEXT:R:Enter your OTP" 120` || commands[1] != "client-auth-nt 8 2" {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+	if ad.Pending.Len() != 0 {
+		t.Error("Client still pending")
+	}
+}
@@ -0,0 +1,202 @@
+package openvpn
+
+import (
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteServer is an OpenVPN server address
+type RemoteServer struct {
+	Host string
+	Port int
+}
+
+// RemoteDecision answers a REMOTE request, Host and Port are used with RemoteModify
+type RemoteDecision struct {
+	Action string
+	Host   string
+	Port   int
+}
+
+// ProxyDecision answers a PROXY request, Host and Port are used with ProxyHTTP and ProxySocks
+type ProxyDecision struct {
+	Type string
+	Host string
+	Port int
+	NCT  bool
+}
+
+// RemoteSelector chooses the server OpenVPN connects to when it runs with
+// --management-query-remote
+type RemoteSelector interface {
+	SelectRemote(remote *core.RemoteEvent) (RemoteDecision, error)
+}
+
+// ProxySelector chooses the proxy OpenVPN uses when it runs with --management-query-proxy
+type ProxySelector interface {
+	SelectProxy(proxy *core.ProxyEvent) (ProxyDecision, error)
+}
+
+// PinnedSelector accepts only the listed remotes and skips the others,
+// e.g. to keep clients on servers of a region.
+type PinnedSelector struct {
+	Remotes []RemoteServer
+}
+
+func (ps PinnedSelector) SelectRemote(remote *core.RemoteEvent) (RemoteDecision, error) {
+	for _, r := range ps.Remotes {
+		if r.Host == remote.Host && (r.Port == 0 || r.Port == remote.Port) {
+			return RemoteDecision{Action: RemoteAccept}, nil
+		}
+	}
+	return RemoteDecision{Action: RemoteSkip}, nil
+}
+
+// RoundRobinSelector replaces every remote with the next server of Remotes
+type RoundRobinSelector struct {
+	Remotes []RemoteServer
+	lock    sync.Mutex
+	next    int
+}
+
+func (rs *RoundRobinSelector) SelectRemote(remote *core.RemoteEvent) (RemoteDecision, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if len(rs.Remotes) == 0 {
+		return RemoteDecision{Action: RemoteAccept}, nil
+	}
+	r := rs.Remotes[rs.next%len(rs.Remotes)]
+	rs.next++
+	return RemoteDecision{Action: RemoteModify, Host: r.Host, Port: r.Port}, nil
+}
+
+// LatencySelector replaces every remote with the server of Remotes that
+// answers fastest to Probe, remotes are accepted when no server answers.
+type LatencySelector struct {
+	Remotes []RemoteServer
+	// Probe measures the latency of a server, it defaults to a TCP dial. A
+	// UDP server does not answer a dial, UDP remotes are accepted unchanged
+	// unless Probe is set.
+	Probe   func(server RemoteServer) (time.Duration, error)
+	Timeout time.Duration
+}
+
+func (ls LatencySelector) SelectRemote(remote *core.RemoteEvent) (RemoteDecision, error) {
+	probe := ls.Probe
+	if probe == nil {
+		if strings.HasPrefix(remote.Protocol, "udp") {
+			return RemoteDecision{Action: RemoteAccept}, nil
+		}
+		probe = ls.dial
+	}
+	var best *RemoteServer
+	var bestLatency time.Duration
+	for i := range ls.Remotes {
+		latency, err := probe(ls.Remotes[i])
+		if err != nil {
+			glog.V(2).Infof("Remote %s:%d unreachable: %v", ls.Remotes[i].Host, ls.Remotes[i].Port, err)
+			continue
+		}
+		if best == nil || latency < bestLatency {
+			best, bestLatency = &ls.Remotes[i], latency
+		}
+	}
+	if best == nil {
+		return RemoteDecision{Action: RemoteAccept}, nil
+	}
+	return RemoteDecision{Action: RemoteModify, Host: best.Host, Port: best.Port}, nil
+}
+
+func (ls LatencySelector) dial(server RemoteServer) (time.Duration, error) {
+	timeout := ls.Timeout
+	if timeout == 0 {
+		timeout = time.Second * 2
+	}
+	start := time.Now()
+	c, err := net.DialTimeout("tcp", net.JoinHostPort(server.Host, strconv.Itoa(server.Port)), timeout)
+	if err != nil {
+		return 0, err
+	}
+	_ = c.Close()
+	return time.Since(start), nil
+}
+
+// StaticProxySelector answers every PROXY request with Proxy, the hosts of
+// Direct and a Proxy without Type are connected to without proxy.
+type StaticProxySelector struct {
+	Proxy  ProxyDecision
+	Direct []string
+}
+
+func (ss StaticProxySelector) SelectProxy(proxy *core.ProxyEvent) (ProxyDecision, error) {
+	for _, host := range ss.Direct {
+		if host == proxy.Host {
+			return ProxyDecision{Type: ProxyNone}, nil
+		}
+	}
+	if ss.Proxy.Type == "" {
+		return ProxyDecision{Type: ProxyNone}, nil
+	}
+	return ss.Proxy, nil
+}
+
+// SetRemoteSelector sets the selector used to answer REMOTE requests
+func (vm *OpenVpnManagement) SetRemoteSelector(selector RemoteSelector) {
+	vm.credentialLock.Lock()
+	defer vm.credentialLock.Unlock()
+	vm.remotes = selector
+}
+
+// SetProxySelector sets the selector used to answer PROXY requests
+func (vm *OpenVpnManagement) SetProxySelector(selector ProxySelector) {
+	vm.credentialLock.Lock()
+	defer vm.credentialLock.Unlock()
+	vm.proxies = selector
+}
+
+func (vm *OpenVpnManagement) handleRemote(evt *core.EventData) {
+	vm.credentialLock.Lock()
+	remotes, proxies := vm.remotes, vm.proxies
+	vm.credentialLock.Unlock()
+
+	if re, ok := evt.Remote(); ok && remotes != nil {
+		vm.spawn(func() {
+			if err := vm.Instance(evt.Instance).answerRemote(remotes, re); err != nil {
+				glog.Errorf("Failed to answer remote request %s:%d: %v", re.Host, re.Port, err)
+			}
+		})
+	} else if pe, ok := evt.Proxy(); ok && proxies != nil {
+		vm.spawn(func() {
+			if err := vm.Instance(evt.Instance).answerProxy(proxies, pe); err != nil {
+				glog.Errorf("Failed to answer proxy request %s: %v", pe.Host, err)
+			}
+		})
+	}
+}
+
+func (c *Commands) answerRemote(selector RemoteSelector, re *core.RemoteEvent) error {
+	decision, err := selector.SelectRemote(re)
+	if err != nil {
+		// Let OpenVPN continue with its own choice
+		glog.Warningf("Remote selection failed: %v", err)
+		decision = RemoteDecision{Action: RemoteAccept}
+	}
+	glog.V(2).Infof("Remote %s:%d -> %+v", re.Host, re.Port, decision)
+	return c.Remote(decision.Action, decision.Host, decision.Port)
+}
+
+func (c *Commands) answerProxy(selector ProxySelector, pe *core.ProxyEvent) error {
+	decision, err := selector.SelectProxy(pe)
+	if err != nil {
+		// OpenVPN waits for an answer, connect without proxy
+		glog.Warningf("Proxy selection failed: %v", err)
+		decision = ProxyDecision{Type: ProxyNone}
+	}
+	glog.V(2).Infof("Proxy %s -> %+v", pe.Host, decision)
+	return c.Proxy(decision.Type, decision.Host, decision.Port, decision.NCT)
+}
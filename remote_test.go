@@ -0,0 +1,105 @@
+package openvpn
+
+import (
+	"errors"
+	"github.com/mungaij83/go-openvpn/core"
+	"testing"
+	"time"
+)
+
+func TestPinnedSelector(t *testing.T) {
+	ps := PinnedSelector{Remotes: []RemoteServer{{Host: "se.vpn.example.com"}}}
+	d, _ := ps.SelectRemote(&core.RemoteEvent{Host: "se.vpn.example.com", Port: 1194, Protocol: "udp"})
+	if d.Action != RemoteAccept {
+		t.Errorf("Expected accept: %+v", d)
+	}
+	d, _ = ps.SelectRemote(&core.RemoteEvent{Host: "us.vpn.example.com", Port: 1194, Protocol: "udp"})
+	if d.Action != RemoteSkip {
+		t.Errorf("Expected skip: %+v", d)
+	}
+}
+
+func TestLatencySelector(t *testing.T) {
+	latency := map[string]time.Duration{"a": time.Millisecond * 50, "b": time.Millisecond * 10}
+	ls := LatencySelector{
+		Remotes: []RemoteServer{{"a", 1194}, {"b", 1195}, {"c", 1196}},
+		Probe: func(server RemoteServer) (time.Duration, error) {
+			l, ok := latency[server.Host]
+			if !ok {
+				return 0, errors.New("timeout")
+			}
+			return l, nil
+		},
+	}
+	d, _ := ls.SelectRemote(&core.RemoteEvent{Host: "a", Port: 1194, Protocol: "udp"})
+	if d.Action != RemoteModify || d.Host != "b" || d.Port != 1195 {
+		t.Errorf("Expected fastest remote: %+v", d)
+	}
+}
+
+func TestLatencySelectorUDP(t *testing.T) {
+	ls := LatencySelector{Remotes: []RemoteServer{{"127.0.0.1", 1}}}
+	d, _ := ls.SelectRemote(&core.RemoteEvent{Host: "a", Port: 1194, Protocol: "udp4"})
+	if d.Action != RemoteAccept {
+		t.Errorf("Expected the UDP remote to be accepted: %+v", d)
+	}
+}
+
+func TestStaticProxySelector(t *testing.T) {
+	ss := StaticProxySelector{
+		Proxy:  ProxyDecision{Type: ProxyHTTP, Host: "proxy.example.com", Port: 3128},
+		Direct: []string{"vpn.example.net"},
+	}
+	d, _ := ss.SelectProxy(&core.ProxyEvent{Index: 1, Protocol: "TCP", Host: "vpn.example.com"})
+	if d != ss.Proxy {
+		t.Errorf("Expected the proxy: %+v", d)
+	}
+	d, _ = ss.SelectProxy(&core.ProxyEvent{Index: 2, Protocol: "TCP", Host: "vpn.example.net"})
+	if d.Type != ProxyNone {
+		t.Errorf("Expected no proxy: %+v", d)
+	}
+}
+
+func TestProxyRequest(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: proxy command succeeded")
+	vm.SetProxySelector(StaticProxySelector{Proxy: ProxyDecision{Type: ProxyHTTP, Host: "proxy.example.com", Port: 3128, NCT: true}})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PROXY:1,TCP,vpn.example.com")
+	commands := f.waitCommands(t, 1)
+	if commands[0] != "proxy HTTP proxy.example.com 3128 nct" {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+func TestRemoteRequest(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: remote command succeeded")
+	vm.SetRemoteSelector(&RoundRobinSelector{Remotes: []RemoteServer{{"vpn1.example.com", 1194}, {"vpn2.example.com", 443}}})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">REMOTE:vpn.example.com,1194,udp")
+	f.waitCommands(t, 1)
+	feed(vm, ">REMOTE:vpn.example.com,1194,udp")
+	commands := f.waitCommands(t, 2)
+	if commands[0] != "remote MOD vpn1.example.com 1194" || commands[1] != "remote MOD vpn2.example.com 443" {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
+
+type failingProxySelector struct{}
+
+func (failingProxySelector) SelectProxy(*core.ProxyEvent) (ProxyDecision, error) {
+	return ProxyDecision{}, errors.New("no proxy configuration")
+}
+
+func TestProxySelectionFailure(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: proxy command succeeded")
+	vm.SetProxySelector(failingProxySelector{})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PROXY:1,UDP,vpn.example.com")
+	commands := f.waitCommands(t, 1)
+	if commands[0] != "proxy NONE" {
+		t.Errorf("Unexpected commands: %v", commands)
+	}
+}
@@ -0,0 +1,75 @@
+package openvpn
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"strings"
+	"time"
+)
+
+// Replayer feeds the notifications of a recorded transcript (see
+// core.TranscriptWriter) through a CommandParser and publishes the events to
+// a bus, e.g. to reproduce a production session against handlers.
+type Replayer struct {
+	// Speed divides the delays between the recorded lines, 1 replays at the
+	// original speed and 0 without delay
+	Speed float64
+	bus   *EventBus
+}
+
+func NewReplayer(bus *EventBus) *Replayer {
+	return &Replayer{Speed: 1, bus: bus}
+}
+
+// Replay publishes the events of entries until ctx is done, the lines of
+// each instance have their own parser.
+func (r *Replayer) Replay(ctx context.Context, entries []core.TranscriptEntry) error {
+	parsers := make(map[string]*core.CommandParser)
+	var last time.Time
+	for _, entry := range entries {
+		// Responses are read by the sessions, only notifications are parsed
+		if entry.Direction != core.DirectionRead || !strings.HasPrefix(entry.Line, ">") {
+			continue
+		}
+		if !last.IsZero() && r.Speed > 0 {
+			if err := sleep(ctx, time.Duration(float64(entry.Time.Sub(last))/r.Speed)); err != nil {
+				return err
+			}
+		}
+		last = entry.Time
+		parser, ok := parsers[entry.Instance]
+		if !ok {
+			p := core.NewCommandParser()
+			parser = &p
+			parsers[entry.Instance] = parser
+		}
+		if evt := parser.ParseEvent(entry.Line); evt != nil {
+			evt.Instance = entry.Instance
+			r.bus.Publish(*evt)
+		}
+	}
+	return ctx.Err()
+}
+
+// ReplayFile replays the transcript stored in path
+func (r *Replayer) ReplayFile(ctx context.Context, path string) error {
+	entries, err := core.ReadTranscriptFile(path)
+	if err != nil {
+		return err
+	}
+	return r.Replay(ctx, entries)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package openvpn
+
+import (
+	"context"
+	"github.com/mungaij83/go-openvpn/core"
+	"strings"
+	"testing"
+	"time"
+)
+
+const transcript = `{"time":"2024-03-01T10:00:00Z","direction":"read","instance":"100","line":">CLIENT:CONNECT,0,1"}
+{"time":"2024-03-01T10:00:00Z","direction":"read","instance":"200","line":">CLIENT:CONNECT,3,0"}
+{"time":"2024-03-01T10:00:00.5Z","direction":"read","instance":"100","line":">CLIENT:ENV,common_name=client1"}
+{"time":"2024-03-01T10:00:01Z","direction":"read","instance":"100","line":">CLIENT:ENV,END"}
+{"time":"2024-03-01T10:00:01Z","direction":"write","instance":"100","line":"client-auth-nt 0 1"}
+{"time":"2024-03-01T10:00:01Z","direction":"read","instance":"100","line":"SUCCESS: client-auth command succeeded"}
+{"time":"2024-03-01T10:00:02Z","direction":"read","instance":"200","line":">CLIENT:ENV,common_name=client2"}
+{"time":"2024-03-01T10:00:02Z","direction":"read","instance":"200","line":">CLIENT:ENV,END"}
+`
+
+func TestReplayer(t *testing.T) {
+	entries, err := core.ReadTranscript(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := NewEventBus()
+	defer bus.Close()
+	sub := bus.Subscribe(EventKinds("CLIENT"), SubscriptionOptions{})
+	r := NewReplayer(bus)
+	// The 2 seconds of the transcript are replayed in 20ms
+	r.Speed = 100
+	start := time.Now()
+	if err = r.Replay(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*20 || elapsed > time.Second {
+		t.Errorf("Unexpected replay duration: %v", elapsed)
+	}
+	for _, expected := range []string{"100:client1", "200:client2"} {
+		evt := receive(t, sub)
+		if evt.EventName() != "CLIENT_CONNECT" || evt.Instance+":"+evt.Get("common_name") != expected {
+			t.Errorf("Expected %s, got %+v", expected, evt)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Speed = 1
+	if err = r.Replay(ctx, entries); err != context.Canceled {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package openvpn
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mungaij83/go-openvpn/core"
+	openssl "github.com/mungaij83/go-openvpn/core/ssl"
+	"strings"
+)
+
+const (
+	SignRSAPKCS1   = "RSA_PKCS1_PADDING"
+	SignRSAPSS     = "RSA_PKCS1_PSS_PADDING"
+	SignRSANoPad   = "RSA_NO_PADDING"
+	SignECDSA      = "ECDSA"
+	SignED25519    = "ED25519"
+	signLineLength = 64
+)
+
+var signHashes = map[string]crypto.Hash{
+	"MD5":    crypto.MD5,
+	"SHA1":   crypto.SHA1,
+	"SHA224": crypto.SHA224,
+	"SHA256": crypto.SHA256,
+	"SHA384": crypto.SHA384,
+	"SHA512": crypto.SHA512,
+}
+
+// Signer signs the data of PK_SIGN requests when OpenVPN runs with
+// --management-external-key. The algorithm is empty for legacy RSA_SIGN
+// requests, otherwise it is e.g. RSA_PKCS1_PADDING or ECDSA.
+type Signer interface {
+	Sign(data []byte, algorithm string) ([]byte, error)
+}
+
+// CryptoSigner implements Signer with a Go crypto.Signer
+type CryptoSigner struct {
+	Key crypto.Signer
+}
+
+// NewCertSigner returns a signer for the private key of cert
+func NewCertSigner(cert *openssl.Cert) (*CryptoSigner, error) {
+	key, err := cert.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &CryptoSigner{Key: key}, nil
+}
+
+func (cs CryptoSigner) Sign(data []byte, algorithm string) ([]byte, error) {
+	opts, err := signerOpts(algorithm, len(data))
+	if err != nil {
+		return nil, err
+	}
+	return cs.Key.Sign(rand.Reader, data, opts)
+}
+
+// signerOpts converts the algorithm of a PK_SIGN request to signer options.
+// PKCS#1 data without hashalg is already DigestInfo encoded, ECDSA data is a
+// digest and the hash is derived from its length.
+func signerOpts(algorithm string, size int) (crypto.SignerOpts, error) {
+	params := strings.Split(algorithm, ",")
+	hash := crypto.Hash(0)
+	saltLength := rsa.PSSSaltLengthEqualsHash
+	for _, p := range params[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "hashalg":
+			h, ok := signHashes[kv[1]]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash algorithm: %s", kv[1])
+			}
+			hash = h
+		case "saltlen":
+			if kv[1] == "max" {
+				saltLength = rsa.PSSSaltLengthAuto
+			}
+		}
+	}
+	switch params[0] {
+	case "", SignRSAPKCS1, SignED25519:
+		return hash, nil
+	case SignECDSA:
+		if hash == 0 {
+			for _, h := range signHashes {
+				if h.Size() == size {
+					hash = h
+				}
+			}
+		}
+		return hash, nil
+	case SignRSAPSS:
+		if hash == 0 {
+			return nil, fmt.Errorf("missing hash algorithm: %s", algorithm)
+		}
+		return &rsa.PSSOptions{SaltLength: saltLength, Hash: hash}, nil
+	}
+	return nil, fmt.Errorf("unsupported signature algorithm: %s", algorithm)
+}
+
+// CertificateProvider returns the PEM certificate for NEED-CERTIFICATE
+// requests when OpenVPN runs with --management-external-cert
+type CertificateProvider interface {
+	Certificate(hint string) (string, error)
+}
+
+// CertProvider implements CertificateProvider with an openssl.Cert
+type CertProvider struct {
+	Cert *openssl.Cert
+}
+
+func (cp CertProvider) Certificate(hint string) (string, error) {
+	pem := cp.Cert.String()
+	if pem == "" {
+		return "", errors.New("no certificate loaded")
+	}
+	return pem, nil
+}
+
+// SetSigner sets the signer used to answer PK_SIGN and RSA_SIGN requests
+func (vm *OpenVpnManagement) SetSigner(signer Signer) {
+	vm.credentialLock.Lock()
+	defer vm.credentialLock.Unlock()
+	vm.signer = signer
+}
+
+// SetCertificateProvider sets the provider used to answer NEED-CERTIFICATE requests
+func (vm *OpenVpnManagement) SetCertificateProvider(provider CertificateProvider) {
+	vm.credentialLock.Lock()
+	defer vm.credentialLock.Unlock()
+	vm.certificates = provider
+}
+
+// Certificate sends a PEM encoded certificate
+func (c *Commands) Certificate(pem string) error {
+	pem = strings.Replace(strings.TrimSpace(pem), "\r\n", "\n", -1)
+	lines := strings.Split(pem, "\n")
+	if err := core.ValidateArgs(lines...); err != nil {
+		return err
+	}
+	return c.commandErr(multiLineCommand("certificate", lines))
+}
+
+// PkSig sends a signature, legacy selects the rsa-sig command of OpenVPN < 2.5.
+// An empty signature reports a failure, OpenVPN aborts the TLS handshake.
+func (c *Commands) PkSig(signature []byte, legacy bool) error {
+	cmd := "pk-sig"
+	if legacy {
+		cmd = "rsa-sig"
+	}
+	return c.commandErr(multiLineCommand(cmd, base64Lines(signature)))
+}
+
+func base64Lines(data []byte) []string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	lines := make([]string, 0)
+	for len(encoded) > signLineLength {
+		lines = append(lines, encoded[:signLineLength])
+		encoded = encoded[signLineLength:]
+	}
+	if encoded == "" {
+		return lines
+	}
+	return append(lines, encoded)
+}
+
+func (vm *OpenVpnManagement) handleCertificate(evt *core.EventData) {
+	ce, ok := evt.Certificate()
+	if !ok {
+		return
+	}
+	vm.credentialLock.Lock()
+	provider := vm.certificates
+	vm.credentialLock.Unlock()
+	if provider == nil {
+		return
+	}
+	vm.spawn(func() {
+		pem, err := provider.Certificate(ce.Hint)
+		if err != nil {
+			glog.Errorf("Failed to get certificate '%s': %v", ce.Hint, err)
+			return
+		}
+		if err = vm.Instance(evt.Instance).Certificate(pem); err != nil {
+			glog.Errorf("Failed to send certificate: %v", err)
+		}
+	})
+}
+
+func (vm *OpenVpnManagement) handleSign(evt *core.EventData) {
+	se, ok := evt.Sign()
+	if !ok {
+		return
+	}
+	vm.credentialLock.Lock()
+	signer := vm.signer
+	vm.credentialLock.Unlock()
+	if signer == nil {
+		return
+	}
+	vm.spawn(func() {
+		signature, err := signer.Sign(se.Data, se.Algorithm)
+		if err != nil {
+			// Answered anyway, OpenVPN would wait for the signature otherwise
+			glog.Errorf("Failed to sign data: %v", err)
+			signature = nil
+		}
+		if err = vm.Instance(evt.Instance).PkSig(signature, se.Legacy); err != nil {
+			glog.Errorf("Failed to send signature: %v", err)
+		}
+	})
+}
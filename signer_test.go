@@ -0,0 +1,153 @@
+package openvpn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	openssl "github.com/mungaij83/go-openvpn/core/ssl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRSASigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := CryptoSigner{Key: key}
+	digest := sha256.Sum256([]byte("data"))
+
+	sig, err := signer.Sign(digest[:], "RSA_PKCS1_PADDING,hashalg=SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Error(err)
+	}
+	sig, err = signer.Sign(digest[:], "RSA_PKCS1_PSS_PADDING,hashalg=SHA256,saltlen=digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rsa.VerifyPSS(&key.PublicKey, crypto.SHA256, digest[:], sig, nil); err != nil {
+		t.Error(err)
+	}
+	if _, err = signer.Sign(digest[:], SignRSANoPad); err == nil {
+		t.Error("Expected unsupported algorithm error")
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, f := newFakeManagement("SUCCESS: pk-sig parameter was provided")
+	vm.SetSigner(CryptoSigner{Key: key})
+	go vm.dispatch()
+	defer vm.cancel()
+	digest := sha256.Sum256([]byte("data"))
+	feed(vm, ">PK_SIGN:"+base64.StdEncoding.EncodeToString(digest[:])+",ECDSA")
+
+	commands := f.waitCommands(t, 1)
+	lines := strings.Split(commands[0], "\n")
+	if lines[0] != "pk-sig" || lines[len(lines)-1] != "END" {
+		t.Fatalf("Unexpected command: %q", commands[0])
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("Invalid signature")
+	}
+}
+
+func TestSignRequestFailure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, f := newFakeManagement("SUCCESS: pk-sig parameter was provided")
+	vm.SetSigner(CryptoSigner{Key: key})
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">PK_SIGN:ZGF0YQ==,"+SignRSANoPad)
+
+	commands := f.waitCommands(t, 1)
+	if commands[0] != "pk-sig\nEND" {
+		t.Errorf("Expected an empty signature, got %q", commands[0])
+	}
+}
+
+func TestCertSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err = ioutil.WriteFile(filepath.Join(dir, "client.key"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "client.crt"), []byte("certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ssl := &openssl.Openssl{Path: dir}
+	cert, err := ssl.LoadCert("client.crt", "client.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewCertSigner(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("data"))
+	sig, err := signer.Sign(digest[:], SignECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("Invalid signature")
+	}
+	if _, err = NewCertSigner(&openssl.Cert{}); err == nil {
+		t.Error("Expected an error for a certificate without key")
+	}
+}
+
+type pemCertificate string
+
+func (pc pemCertificate) Certificate(hint string) (string, error) {
+	return string(pc), nil
+}
+
+func TestCertificateRequest(t *testing.T) {
+	vm, f := newFakeManagement("SUCCESS: certificate parameter was provided")
+	vm.SetCertificateProvider(pemCertificate("-----BEGIN CERTIFICATE-----\r\nMIIB\r\n-----END CERTIFICATE-----\r\n"))
+	go vm.dispatch()
+	defer vm.cancel()
+	feed(vm, ">NEED-CERTIFICATE:macosx-keychain:subject:o=OpenVPN-TEST")
+
+	commands := f.waitCommands(t, 1)
+	expected := "certificate\n-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\nEND"
+	if commands[0] != expected {
+		t.Errorf("Unexpected command: %q", commands[0])
+	}
+}